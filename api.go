@@ -1,9 +1,9 @@
 package sc
 
 import (
-	"bytes"
-	"fmt"
+	"context"
 	"io"
+	"io/ioutil"
 	"net/http"
 )
 
@@ -16,32 +16,91 @@ type APIEngine interface {
 	Process(io.ReadCloser) (interface{}, error)
 }
 
+// optionally implemented by engines whose requests can be cancelled
+type ContextAPIEngine interface {
+	GetContext(context.Context, Reference) (*http.Response, error)
+}
+
+// optionally implemented by engines which can write, given http.MethodPut
+// for puts, http.MethodPatch for merges or http.MethodDelete, though they
+// may use other methods, e.g. POST for puts
+type WriterAPIEngine interface {
+	Write(ctx context.Context, method string, r Reference, i interface{}) (*http.Response, error)
+}
+
 func NewAPICombinator(e APIEngine) *APICombinator {
 	return &APICombinator{e: e}
 }
 
 func (a APICombinator) Get(r Reference) (interface{}, error) {
-	resp, err := a.e.Get(r)
-	if err != nil {
-		return nil, err
-	}
-	if resp.StatusCode != 200 {
-		defer resp.Body.Close()
-		w := new(bytes.Buffer)
-		if _, err := io.Copy(w, resp.Body); err != nil {
+	return a.GetContext(context.Background(), r)
+}
+
+func (a APICombinator) GetContext(ctx context.Context, r Reference) (interface{}, error) {
+	var resp *http.Response
+	if e, ok := a.e.(ContextAPIEngine); ok {
+		x, err := e.GetContext(ctx, r)
+		if err != nil {
+			return nil, err
+		}
+		resp = x
+	} else {
+		if err := ctx.Err(); err != nil {
 			return nil, err
 		}
-		return w.Bytes(), fmt.Errorf("bad status: %q", resp.Status)
+		x, err := a.e.Get(r)
+		if err != nil {
+			return nil, err
+		}
+		resp = x
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		return nil, responseError(r, resp)
 	}
 	return a.e.Process(resp.Body)
 }
 
-func (a APICombinator) Put(Reference, interface{}) error {
-	return unsupported(a, "Put")
+func (a APICombinator) Put(r Reference, i interface{}) error {
+	return a.PutContext(context.Background(), r, i)
 }
-func (a APICombinator) Delete(Reference) error {
-	return unsupported(a, "Delete")
+
+func (a APICombinator) PutContext(ctx context.Context, r Reference, i interface{}) error {
+	return a.write(ctx, http.MethodPut, "Put", r, i)
+}
+
+func (a APICombinator) write(ctx context.Context, method, name string, r Reference, i interface{}) error {
+	e, ok := a.e.(WriterAPIEngine)
+	if !ok {
+		return unsupported(a, name)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	resp, err := e.Write(ctx, method, r, i)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return responseError(r, resp)
+	}
+	_, err = io.Copy(ioutil.Discard, resp.Body)
+	return err
 }
-func (a APICombinator) Merge(Reference, interface{}) error {
-	return unsupported(a, "Merge")
+
+func (a APICombinator) Delete(r Reference) error {
+	return a.DeleteContext(context.Background(), r)
+}
+
+func (a APICombinator) DeleteContext(ctx context.Context, r Reference) error {
+	return a.write(ctx, http.MethodDelete, "Delete", r, nil)
+}
+
+func (a APICombinator) Merge(r Reference, i interface{}) error {
+	return a.MergeContext(context.Background(), r, i)
+}
+
+func (a APICombinator) MergeContext(ctx context.Context, r Reference, i interface{}) error {
+	return a.write(ctx, http.MethodPatch, "Merge", r, i)
 }
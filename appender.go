@@ -2,6 +2,7 @@ package sc
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 )
@@ -16,54 +17,79 @@ func NewAppender(c StorageCombinator) *Appender {
 }
 
 func (a Appender) Get(r Reference) (interface{}, error) {
-	return a.c.Get(r)
+	return a.GetContext(context.Background(), r)
+}
+
+func (a Appender) GetContext(ctx context.Context, r Reference) (interface{}, error) {
+	return GetContext(ctx, a.c, r)
 }
 
 func (a Appender) Put(r Reference, i interface{}) error {
-	return a.c.Put(r, i)
+	return a.PutContext(context.Background(), r, i)
+}
+
+func (a Appender) PutContext(ctx context.Context, r Reference, i interface{}) error {
+	return PutContext(ctx, a.c, r, i)
 }
 
 func (a Appender) Delete(r Reference) error {
-	return a.c.Delete(r)
+	return a.DeleteContext(context.Background(), r)
+}
+
+func (a Appender) DeleteContext(ctx context.Context, r Reference) error {
+	return DeleteContext(ctx, a.c, r)
 }
 
 func (a Appender) Merge(r Reference, i interface{}) error {
-	original, err := a.c.Get(r)
-	if err != nil {
-		return err
-	}
-	w := new(bytes.Buffer)
-	append := func(i interface{}) error {
-		switch t := i.(type) {
-		case []byte:
-			if _, err := w.Write(t); err != nil {
-				return err
-			}
-		case string:
-			if _, err := w.WriteString(t); err != nil {
-				return err
-			}
-		case io.Reader:
-			if _, err := io.Copy(w, t); err != nil {
-				return err
-			}
-		case io.ReadCloser:
-			if _, err := io.Copy(w, t); err != nil {
-				return err
-			}
-			if err := t.Close(); err != nil {
+	return a.MergeContext(context.Background(), r, i)
+}
+
+// appends by rewriting; concurrent merges don't clobber each other
+// if the embedded combinator supports conditional puts.
+// existing content is streamed, while the addition is buffered
+// so the merge can be retried
+func (a Appender) MergeContext(ctx context.Context, r Reference, i interface{}) error {
+	addition := new(bytes.Buffer)
+	switch t := i.(type) {
+	case []byte:
+		addition.Write(t)
+	case string:
+		addition.WriteString(t)
+	case io.Reader:
+		if _, err := io.Copy(addition, contextReader{ctx: ctx, r: t}); err != nil {
+			return err
+		}
+		if c, ok := t.(io.Closer); ok {
+			if err := c.Close(); err != nil {
 				return err
 			}
-		default:
-			return fmt.Errorf("unhandled type: %T", t)
 		}
-		return nil
+	default:
+		return fmt.Errorf("unhandled type: %T", t)
 	}
-	if err := append(original); err != nil {
-		return err
-	}
-	if err := append(i); err != nil {
-		return err
-	}
-	return a.c.Put(r, w.Bytes())
+	return retryConflicts(ctx, func() error {
+		m, conditional, err := beginSwap(ctx, a.c, r)
+		if err != nil {
+			return err
+		}
+		original, err := GetStream(ctx, a.c, r)
+		if err != nil {
+			return err
+		}
+		defer original.Close()
+		merged := io.MultiReader(original, bytes.NewReader(addition.Bytes()))
+		return endSwap(ctx, a.c, r, merged, m, conditional)
+	})
+}
+
+func (a Appender) List(ctx context.Context, prefix Reference, opts ListOptions) (*ListPage, error) {
+	return List(ctx, a.c, prefix, opts)
+}
+
+func (a Appender) Stat(ctx context.Context, r Reference) (*Metadata, error) {
+	return Stat(ctx, a.c, r)
+}
+
+func (a Appender) GetStream(ctx context.Context, r Reference) (io.ReadCloser, error) {
+	return GetStream(ctx, a.c, r)
 }
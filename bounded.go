@@ -0,0 +1,314 @@
+package sc
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"sync"
+)
+
+type EvictionPolicy int
+
+const (
+	LRU EvictionPolicy = iota // least recently used goes first
+	LFU                       // least frequently used goes first, ties by recency
+)
+
+func (p EvictionPolicy) String() string {
+	switch p {
+	case LRU:
+		return "lru"
+	case LFU:
+		return "lfu"
+	default:
+		return fmt.Sprintf("policy(%d)", int(p))
+	}
+}
+
+// zero limits are unbounded
+type BoundedOptions struct {
+	MaxEntries int
+	MaxBytes   int64
+	Policy     EvictionPolicy
+}
+
+type BoundedStats struct {
+	Hits, Misses, Evictions uint64
+	Entries                 int
+	Bytes                   int64
+}
+
+func (s BoundedStats) String() string {
+	return fmt.Sprintf("%d hits, %d misses, %d evictions, %d entries, %d bytes",
+		s.Hits, s.Misses, s.Evictions, s.Entries, s.Bytes)
+}
+
+// in-memory store which evicts entries to stay within its limits,
+// suitable as the cache layer of a Cache
+type BoundedMemory struct {
+	opts    BoundedOptions
+	mu      sync.Mutex
+	entries map[string]*boundedEntry
+	queue   evictionQueue
+	tick    uint64
+	stats   BoundedStats
+}
+
+type boundedEntry struct {
+	key   string
+	i     interface{}
+	size  int64
+	freq  uint64
+	tick  uint64 // of last use
+	index int    // in the queue
+}
+
+func NewBoundedMemory(opts BoundedOptions) (*BoundedMemory, error) {
+	if opts.MaxEntries < 0 || opts.MaxBytes < 0 {
+		return nil, fmt.Errorf("negative limits: %+v", opts)
+	}
+	switch opts.Policy {
+	case LRU, LFU:
+	default:
+		return nil, fmt.Errorf("unsupported eviction policy %v", opts.Policy)
+	}
+	return &BoundedMemory{
+		opts:    opts,
+		entries: make(map[string]*boundedEntry),
+		queue:   evictionQueue{lfu: opts.Policy == LFU},
+	}, nil
+}
+
+// Stats returns the counters so far, and current usage
+func (b *BoundedMemory) Stats() BoundedStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := b.stats
+	s.Entries = len(b.entries)
+	return s
+}
+
+// bytes an item counts against the limit
+func footprint(i interface{}) int64 {
+	switch i.(type) {
+	case []byte, string:
+		return sizeOf(i)
+	}
+	if buf, err := Blob(i); err == nil {
+		return int64(len(buf))
+	}
+	return 0
+}
+
+// marks an entry used, with lock held
+func (b *BoundedMemory) touch(e *boundedEntry) {
+	b.tick++
+	e.tick = b.tick
+	e.freq++
+	heap.Fix(&b.queue, e.index)
+}
+
+func (b *BoundedMemory) remove(e *boundedEntry) {
+	heap.Remove(&b.queue, e.index)
+	delete(b.entries, e.key)
+	b.stats.Bytes -= e.size
+}
+
+// evicts until within limits, sparing the entry just written
+// unless it can't fit on its own, with lock held
+func (b *BoundedMemory) evict(keep *boundedEntry) {
+	over := func() bool {
+		return (b.opts.MaxEntries > 0 && len(b.entries) > b.opts.MaxEntries) ||
+			(b.opts.MaxBytes > 0 && b.stats.Bytes > b.opts.MaxBytes)
+	}
+	heap.Remove(&b.queue, keep.index)
+	for over() && len(b.queue.entries) > 0 {
+		e := heap.Pop(&b.queue).(*boundedEntry)
+		delete(b.entries, e.key)
+		b.stats.Bytes -= e.size
+		b.stats.Evictions++
+	}
+	heap.Push(&b.queue, keep)
+	if over() {
+		b.remove(keep)
+		b.stats.Evictions++
+	}
+}
+
+func (b *BoundedMemory) Get(r Reference) (interface{}, error) {
+	return b.GetContext(context.Background(), r)
+}
+
+func (b *BoundedMemory) GetContext(ctx context.Context, r Reference) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e, ok := b.entries[key(r)]
+	if !ok {
+		b.stats.Misses++
+		return nil, NotFound
+	}
+	b.stats.Hits++
+	b.touch(e)
+	return e.i, nil
+}
+
+func (b *BoundedMemory) Put(r Reference, i interface{}) error {
+	return b.PutContext(context.Background(), r, i)
+}
+
+func (b *BoundedMemory) PutContext(ctx context.Context, r Reference, i interface{}) error {
+	return b.PutIf(ctx, r, i, Conditions{})
+}
+
+func (b *BoundedMemory) PutIf(ctx context.Context, r Reference, i interface{}, cond Conditions) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	i, err := storable(i)
+	if err != nil {
+		return err
+	}
+	size := footprint(i)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.checkLocked(r, cond); err != nil {
+		return err
+	}
+	k := key(r)
+	e, ok := b.entries[k]
+	if ok {
+		b.stats.Bytes += size - e.size
+		e.i, e.size = i, size
+		b.touch(e)
+	} else {
+		b.tick++
+		e = &boundedEntry{key: k, i: i, size: size, freq: 1, tick: b.tick}
+		b.entries[k] = e
+		heap.Push(&b.queue, e)
+		b.stats.Bytes += size
+	}
+	b.evict(e)
+	return nil
+}
+
+func (b *BoundedMemory) Merge(r Reference, i interface{}) error {
+	return b.MergeContext(context.Background(), r, i)
+}
+
+func (b *BoundedMemory) MergeContext(context.Context, Reference, interface{}) error {
+	return unimplemented(b, "Merge")
+}
+
+func (b *BoundedMemory) Delete(r Reference) error {
+	return b.DeleteContext(context.Background(), r)
+}
+
+func (b *BoundedMemory) DeleteContext(ctx context.Context, r Reference) error {
+	return b.DeleteIf(ctx, r, Conditions{})
+}
+
+func (b *BoundedMemory) DeleteIf(ctx context.Context, r Reference, cond Conditions) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e, ok := b.entries[key(r)]
+	if !ok {
+		return NotFound
+	}
+	if err := b.checkLocked(r, cond); err != nil {
+		return err
+	}
+	b.remove(e)
+	return nil
+}
+
+func (b *BoundedMemory) checkLocked(r Reference, cond Conditions) error {
+	if cond.IsZero() {
+		return nil
+	}
+	var m *Metadata
+	if e, ok := b.entries[key(r)]; ok {
+		var err error
+		if m, err = memoryMetadata(r, e.i); err != nil {
+			return err
+		}
+	}
+	return cond.check(r, m)
+}
+
+// doesn't count as a use of the entry
+func (b *BoundedMemory) Stat(ctx context.Context, r Reference) (*Metadata, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	e, ok := b.entries[key(r)]
+	b.mu.Unlock()
+	if !ok {
+		return nil, NotFound
+	}
+	return memoryMetadata(r, e.i)
+}
+
+func (b *BoundedMemory) List(ctx context.Context, prefix Reference, opts ListOptions) (*ListPage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	pu := prefix.URI()
+	var entries []Entry
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for k, e := range b.entries {
+		entry, err := keyEntry(pu, k, e.i)
+		if err != nil {
+			return nil, err
+		}
+		if entry != nil {
+			entries = append(entries, *entry)
+		}
+	}
+	return paginate(pu.Path, entries, opts), nil
+}
+
+// min-heap of entries, next to evict first
+type evictionQueue struct {
+	lfu     bool
+	entries []*boundedEntry
+}
+
+func (q evictionQueue) Len() int {
+	return len(q.entries)
+}
+
+func (q evictionQueue) Less(i, j int) bool {
+	a, b := q.entries[i], q.entries[j]
+	if q.lfu && a.freq != b.freq {
+		return a.freq < b.freq
+	}
+	return a.tick < b.tick
+}
+
+func (q evictionQueue) Swap(i, j int) {
+	q.entries[i], q.entries[j] = q.entries[j], q.entries[i]
+	q.entries[i].index = i
+	q.entries[j].index = j
+}
+
+func (q *evictionQueue) Push(x interface{}) {
+	e := x.(*boundedEntry)
+	e.index = len(q.entries)
+	q.entries = append(q.entries, e)
+}
+
+func (q *evictionQueue) Pop() interface{} {
+	n := len(q.entries)
+	e := q.entries[n-1]
+	q.entries[n-1] = nil
+	q.entries = q.entries[:n-1]
+	return e
+}
@@ -1,54 +1,369 @@
 package sc
 
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sync"
+	"time"
+)
+
 func NewCache(underlying, cache StorageCombinator) *Cache {
-	return &Cache{
-		u: underlying,
-		c: cache,
+	return NewCacheWithOptions(underlying, cache, CacheOptions{})
+}
+
+// zero options cache everything forever
+type CacheOptions struct {
+	// how long entries stay fresh, zero meaning forever
+	TTL time.Duration
+	// overrides TTL per reference if non-nil
+	TTLFor func(Reference) time.Duration
+	// how long past expiry an entry may still be served,
+	// while it's refreshed in the background
+	StaleWhileRevalidate time.Duration
+	// how long to remember that something wasn't found, zero meaning not at all
+	NegativeTTL time.Duration
+
+	// writes land in the cache layer and are flushed to the underlying
+	// combinator in the background; call Flush or Close to drain
+	WriteBehind bool
+	// how often to flush, DefaultFlushInterval if zero
+	FlushInterval time.Duration
+	// writes held before further writes block, DefaultMaxPending if zero
+	MaxPending int
+	// called for each write that fails to flush, which is then retried
+	OnFlushError func(Reference, error)
+
+	// concurrent misses for the same reference make just one underlying get
+	Coalesce bool
+}
+
+func (o CacheOptions) expiring() bool {
+	return o.TTL > 0 || o.TTLFor != nil || o.NegativeTTL > 0
+}
+
+func (o CacheOptions) ttl(r Reference) time.Duration {
+	if o.TTLFor != nil {
+		return o.TTLFor(r)
+	}
+	return o.TTL
+}
+
+func NewCacheWithOptions(underlying, cache StorageCombinator, opts CacheOptions) *Cache {
+	if opts.Coalesce {
+		underlying = NewCoalescer(underlying)
+	}
+	c := &Cache{
+		u:            underlying,
+		c:            cache,
+		opts:         opts,
+		revalidating: new(sync.Map),
+	}
+	if opts.WriteBehind {
+		c.wb = newWriteBehind(underlying, opts)
 	}
+	return c
 }
 
 type Cache struct {
-	u, c, tmp StorageCombinator
+	u, c, tmp    StorageCombinator
+	opts         CacheOptions
+	revalidating *sync.Map    // references being refreshed in the background
+	wb           *writeBehind // nil unless in write-behind mode
+}
+
+// Flush writes anything pending to the underlying combinator,
+// returning the first failure since the last call
+func (self Cache) Flush(ctx context.Context) error {
+	if self.wb == nil {
+		return nil
+	}
+	return self.wb.Flush(ctx)
+}
+
+// Close flushes and stops background flushing; further writes fail
+func (self Cache) Close() error {
+	if self.wb == nil {
+		return nil
+	}
+	return self.wb.Close()
+}
+
+// where expiry records live in the cache layer
+const CacheMetaPrefix = "/.cache-meta"
+
+// expiry of a cached entry, or of the knowledge that it's missing
+type cacheRecord struct {
+	Expires time.Time `json:",omitempty"` // zero for never
+	Missing bool      `json:",omitempty"`
+}
+
+func (cr cacheRecord) fresh(now time.Time) bool {
+	return cr.Expires.IsZero() || now.Before(cr.Expires)
+}
+
+func cacheRecordRef(r Reference) Reference {
+	return NewRef(path.Join(CacheMetaPrefix, hash(key(r))))
+}
+
+// nil if there's no record
+func (self Cache) record(ctx context.Context, r Reference) (*cacheRecord, error) {
+	i, err := GetContext(ctx, self.c, cacheRecordRef(r))
+	if errors.Is(err, NotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	buf, err := Blob(i)
+	if err != nil {
+		return nil, err
+	}
+	var cr cacheRecord
+	if err := json.Unmarshal(buf, &cr); err != nil {
+		return nil, err
+	}
+	return &cr, nil
+}
+
+func (self Cache) putRecord(ctx context.Context, r Reference, missing bool) error {
+	if !self.opts.expiring() {
+		return nil
+	}
+	cr := cacheRecord{Missing: missing}
+	ttl := self.opts.ttl(r)
+	if missing {
+		ttl = self.opts.NegativeTTL
+	}
+	if ttl > 0 {
+		cr.Expires = time.Now().Add(ttl)
+	}
+	buf, err := json.Marshal(cr)
+	if err != nil {
+		return err
+	}
+	return PutContext(ctx, self.c, cacheRecordRef(r), buf)
 }
 
 func (self Cache) Get(r Reference) (interface{}, error) {
-	if i, err := self.c.Get(r); err == nil {
-		return i, err
+	return self.GetContext(context.Background(), r)
+}
+
+func (self Cache) GetContext(ctx context.Context, r Reference) (interface{}, error) {
+	if p, ok := self.wb.lookup(r); ok {
+		if p.deleted {
+			return nil, fmt.Errorf("%w (pending delete; %v)", NotFound, r)
+		}
+		return p.i, nil
+	}
+	if !self.opts.expiring() {
+		if i, err := GetContext(ctx, self.c, r); err == nil {
+			return i, err
+		}
+		return self.fill(ctx, r)
+	}
+	cr, err := self.record(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	switch {
+	case cr == nil:
+	case cr.Missing && cr.fresh(now):
+		return nil, fmt.Errorf("%w (cached; %v)", NotFound, r)
+	case cr.Missing:
+	case cr.fresh(now):
+		if i, err := GetContext(ctx, self.c, r); err == nil {
+			return i, nil
+		}
+	case now.Before(cr.Expires.Add(self.opts.StaleWhileRevalidate)):
+		if i, err := GetContext(ctx, self.c, r); err == nil {
+			self.revalidate(r)
+			return i, nil
+		}
 	}
-	if i, err := self.u.Get(r); err != nil {
+	return self.fill(ctx, r)
+}
+
+// gets from underlying into the cache layer
+func (self Cache) fill(ctx context.Context, r Reference) (interface{}, error) {
+	i, err := GetContext(ctx, self.u, r)
+	if errors.Is(err, NotFound) && self.opts.NegativeTTL > 0 {
+		if err := self.invalidate(ctx, r); err != nil {
+			return nil, err
+		}
+		if err := self.putRecord(ctx, r, true); err != nil {
+			return nil, err
+		}
 		return nil, err
-	} else if err := self.c.Put(r, i); err != nil {
+	} else if err != nil {
 		return nil, err
 	}
-	return self.Get(r)
+	if err := PutContext(ctx, self.c, r, i); err != nil {
+		return nil, err
+	}
+	if err := self.putRecord(ctx, r, false); err != nil {
+		return nil, err
+	}
+	if i, err := GetContext(ctx, self.c, r); err == nil {
+		return i, nil
+	}
+	// cache layer may have dropped it already
+	return GetContext(ctx, self.u, r)
+}
+
+// refreshes in the background, at most once at a time per reference
+func (self Cache) revalidate(r Reference) {
+	k := key(r)
+	if _, busy := self.revalidating.LoadOrStore(k, true); busy {
+		return
+	}
+	go func() {
+		defer self.revalidating.Delete(k)
+		i, err := self.fill(context.Background(), r)
+		if c, ok := i.(io.Closer); ok && err == nil {
+			c.Close()
+		}
+	}()
 }
 
 func (self Cache) Put(r Reference, i interface{}) error {
-	return self.update(r, i, self.u.Put)
+	return self.PutContext(context.Background(), r, i)
+}
+
+func (self Cache) PutContext(ctx context.Context, r Reference, i interface{}) error {
+	if self.wb != nil {
+		return self.putBehind(ctx, r, i)
+	}
+	return self.update(ctx, r, i, PutContext)
+}
+
+// puts to the cache layer only, queueing the write to underlying
+func (self Cache) putBehind(ctx context.Context, r Reference, i interface{}) error {
+	i, err := storable(i)
+	if err != nil {
+		return err
+	}
+	if err := self.wb.enqueue(ctx, r, i, false); err != nil {
+		return err
+	}
+	if err := PutContext(ctx, self.c, r, i); err != nil {
+		return err
+	}
+	return self.putRecord(ctx, r, false)
+}
+
+// flushes pending writes before operations which need underlying to be current
+func (self Cache) drain(ctx context.Context) error {
+	if self.wb == nil {
+		return nil
+	}
+	return self.wb.flush(ctx)
 }
 
 // merges with underlying, but puts merged version to cache
 func (self Cache) Merge(r Reference, i interface{}) error {
-	return self.update(r, i, self.u.Merge)
+	return self.MergeContext(context.Background(), r, i)
+}
+
+func (self Cache) MergeContext(ctx context.Context, r Reference, i interface{}) error {
+	return self.update(ctx, r, i, MergeContext)
 }
 
-func (self Cache) update(r Reference, i interface{}, mutator func(Reference, interface{}) error) error {
-	if err := self.c.Delete(r); err != nil {
+func (self Cache) update(ctx context.Context, r Reference, i interface{}, mutator contextMutator) error {
+	if err := self.drain(ctx); err != nil {
+		return err
+	}
+	if err := self.invalidate(ctx, r); err != nil {
 		return err
 	}
-	if err := mutator(r, i); err != nil {
+	if err := mutator(ctx, self.u, r, i); err != nil {
 		return err
 	}
-	tmpCopy, err := self.u.Get(r)
+	tmpCopy, err := GetContext(ctx, self.u, r)
 	if err != nil {
 		return err
 	}
-	return self.c.Put(r, tmpCopy)
+	if err := PutContext(ctx, self.c, r, tmpCopy); err != nil {
+		return err
+	}
+	return self.putRecord(ctx, r, false)
 }
 
 func (self Cache) Delete(r Reference) error {
-	if err := self.c.Delete(r); err != nil {
+	return self.DeleteContext(context.Background(), r)
+}
+
+func (self Cache) DeleteContext(ctx context.Context, r Reference) error {
+	if self.wb != nil {
+		if err := self.wb.enqueue(ctx, r, nil, true); err != nil {
+			return err
+		}
+		return self.invalidate(ctx, r)
+	}
+	if err := self.invalidate(ctx, r); err != nil {
 		return err
 	}
-	return self.u.Delete(r)
+	return DeleteContext(ctx, self.u, r)
+}
+
+// removes any cached copy and its expiry, which needn't exist
+func (self Cache) invalidate(ctx context.Context, r Reference) error {
+	refs := []Reference{r}
+	if self.opts.expiring() {
+		refs = append(refs, cacheRecordRef(r))
+	}
+	for _, r := range refs {
+		if err := DeleteContext(ctx, self.c, r); err != nil && !errors.Is(err, NotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// lists the underlying combinator, which is authoritative
+func (self Cache) List(ctx context.Context, prefix Reference, opts ListOptions) (*ListPage, error) {
+	if err := self.drain(ctx); err != nil {
+		return nil, err
+	}
+	return List(ctx, self.u, prefix, opts)
+}
+
+func (self Cache) Stat(ctx context.Context, r Reference) (*Metadata, error) {
+	if err := self.drain(ctx); err != nil {
+		return nil, err
+	}
+	return Stat(ctx, self.u, r)
+}
+
+func (self Cache) PutIf(ctx context.Context, r Reference, i interface{}, cond Conditions) error {
+	return self.update(ctx, r, i, func(ctx context.Context, c StorageCombinator, r Reference, i interface{}) error {
+		return PutIf(ctx, c, r, i, cond)
+	})
+}
+
+func (self Cache) DeleteIf(ctx context.Context, r Reference, cond Conditions) error {
+	if err := self.drain(ctx); err != nil {
+		return err
+	}
+	if err := self.invalidate(ctx, r); err != nil {
+		return err
+	}
+	return DeleteIf(ctx, self.u, r, cond)
+}
+
+// streams from the cache layer if possible
+func (self Cache) GetStream(ctx context.Context, r Reference) (io.ReadCloser, error) {
+	if _, ok := self.wb.lookup(r); !ok && !self.opts.expiring() {
+		if rc, err := GetStream(ctx, self.c, r); err == nil {
+			return rc, nil
+		}
+	}
+	i, err := self.GetContext(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	return Reader(i)
 }
@@ -0,0 +1,165 @@
+package sc
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// collapses concurrent gets of the same reference into one call
+// to the underlying combinator, sharing its result among the callers
+type Coalescer struct {
+	calls, shared uint64 // first, for atomic alignment
+
+	c       StorageCombinator
+	lock    sync.Mutex
+	flights map[string]*flight
+}
+
+// a get in progress
+type flight struct {
+	done    chan struct{}
+	i       interface{}
+	err     error
+	waiters int // callers still interested
+	cancel  context.CancelFunc
+}
+
+func NewCoalescer(c StorageCombinator) *Coalescer {
+	return &Coalescer{
+		c:       c,
+		flights: make(map[string]*flight),
+	}
+}
+
+type CoalescerStats struct {
+	Calls  uint64 // made to the underlying combinator
+	Shared uint64 // gets answered by another caller's call
+}
+
+func (co *Coalescer) Stats() CoalescerStats {
+	return CoalescerStats{
+		Calls:  atomic.LoadUint64(&co.calls),
+		Shared: atomic.LoadUint64(&co.shared),
+	}
+}
+
+func (co *Coalescer) Get(r Reference) (interface{}, error) {
+	return co.GetContext(context.Background(), r)
+}
+
+// the underlying call is only canceled once every caller waiting on it has given up
+func (co *Coalescer) GetContext(ctx context.Context, r Reference) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	k := key(r)
+	co.lock.Lock()
+	f, ok := co.flights[k]
+	if ok {
+		f.waiters++
+		atomic.AddUint64(&co.shared, 1)
+	} else {
+		fctx, cancel := context.WithCancel(context.Background())
+		f = &flight{done: make(chan struct{}), waiters: 1, cancel: cancel}
+		co.flights[k] = f
+		atomic.AddUint64(&co.calls, 1)
+		go co.fly(fctx, k, f, r)
+	}
+	co.lock.Unlock()
+	select {
+	case <-f.done:
+		return f.i, f.err
+	case <-ctx.Done():
+		co.lock.Lock()
+		f.waiters--
+		if f.waiters == 0 {
+			f.cancel()
+			if co.flights[k] == f {
+				delete(co.flights, k)
+			}
+		}
+		co.lock.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+func (co *Coalescer) fly(ctx context.Context, k string, f *flight, r Reference) {
+	defer f.cancel()
+	i, err := GetContext(ctx, co.c, r)
+	if err == nil {
+		// every caller needs to be able to read it
+		i, err = storable(i)
+	}
+	f.i, f.err = i, err
+	co.forget(k, f)
+	close(f.done)
+}
+
+// stops new gets from joining a flight
+func (co *Coalescer) forget(k string, f *flight) {
+	co.lock.Lock()
+	defer co.lock.Unlock()
+	if co.flights[k] == f {
+		delete(co.flights, k)
+	}
+}
+
+// after a write completes, gets mustn't join a flight which began before it
+func (co *Coalescer) wrote(r Reference) {
+	k := key(r)
+	co.lock.Lock()
+	defer co.lock.Unlock()
+	delete(co.flights, k)
+}
+
+func (co *Coalescer) Put(r Reference, i interface{}) error {
+	return co.PutContext(context.Background(), r, i)
+}
+
+func (co *Coalescer) PutContext(ctx context.Context, r Reference, i interface{}) error {
+	defer co.wrote(r)
+	return PutContext(ctx, co.c, r, i)
+}
+
+func (co *Coalescer) Delete(r Reference) error {
+	return co.DeleteContext(context.Background(), r)
+}
+
+func (co *Coalescer) DeleteContext(ctx context.Context, r Reference) error {
+	defer co.wrote(r)
+	return DeleteContext(ctx, co.c, r)
+}
+
+func (co *Coalescer) Merge(r Reference, i interface{}) error {
+	return co.MergeContext(context.Background(), r, i)
+}
+
+func (co *Coalescer) MergeContext(ctx context.Context, r Reference, i interface{}) error {
+	defer co.wrote(r)
+	return MergeContext(ctx, co.c, r, i)
+}
+
+func (co *Coalescer) PutIf(ctx context.Context, r Reference, i interface{}, cond Conditions) error {
+	defer co.wrote(r)
+	return PutIf(ctx, co.c, r, i, cond)
+}
+
+func (co *Coalescer) DeleteIf(ctx context.Context, r Reference, cond Conditions) error {
+	defer co.wrote(r)
+	return DeleteIf(ctx, co.c, r, cond)
+}
+
+func (co *Coalescer) List(ctx context.Context, prefix Reference, opts ListOptions) (*ListPage, error) {
+	return List(ctx, co.c, prefix, opts)
+}
+
+func (co *Coalescer) Stat(ctx context.Context, r Reference) (*Metadata, error) {
+	return Stat(ctx, co.c, r)
+}
+
+// streams can't be shared, so these aren't coalesced
+func (co *Coalescer) GetStream(ctx context.Context, r Reference) (io.ReadCloser, error) {
+	return GetStream(ctx, co.c, r)
+}
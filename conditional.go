@@ -0,0 +1,159 @@
+package sc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// if a conditional write's preconditions don't hold, combinator should return something that wraps this error:
+var PreconditionFailed = errors.New("precondition failed")
+
+// Conditions are preconditions on the current state of a reference,
+// all of which must hold for a conditional write to proceed
+type Conditions struct {
+	// etag the current content must have, or "*" for any existing content
+	IfMatch string `json:",omitempty"`
+	// etag the current content must not have, or "*" to only create
+	IfNoneMatch string `json:",omitempty"`
+	// version the current content must have
+	IfVersion string `json:",omitempty"`
+}
+
+// CreateOnly is the condition that nothing exists yet at a reference
+var CreateOnly = Conditions{IfNoneMatch: "*"}
+
+// ConditionalCombinator is implemented by combinators which can
+// atomically check preconditions and write
+type ConditionalCombinator interface {
+	PutIf(context.Context, Reference, interface{}, Conditions) error
+	DeleteIf(context.Context, Reference, Conditions) error
+}
+
+// PutIf puts only if the conditions hold, if c supports it
+func PutIf(ctx context.Context, c StorageCombinator, r Reference, i interface{}, cond Conditions) error {
+	cc, ok := c.(ConditionalCombinator)
+	if !ok {
+		return unsupported(c, "PutIf")
+	}
+	return cc.PutIf(ctx, r, i, cond)
+}
+
+// DeleteIf deletes only if the conditions hold, if c supports it
+func DeleteIf(ctx context.Context, c StorageCombinator, r Reference, cond Conditions) error {
+	cc, ok := c.(ConditionalCombinator)
+	if !ok {
+		return unsupported(c, "DeleteIf")
+	}
+	return cc.DeleteIf(ctx, r, cond)
+}
+
+func (c Conditions) IsZero() bool {
+	return c == Conditions{}
+}
+
+// checks conditions against current metadata, nil if nothing exists
+func (c Conditions) check(r Reference, m *Metadata) error {
+	fail := func(format string, args ...interface{}) error {
+		return fmt.Errorf("%w (%s; %v)", PreconditionFailed, fmt.Sprintf(format, args...), r)
+	}
+	switch {
+	case c.IfMatch == "":
+	case m == nil:
+		return fail("if-match %q but missing", c.IfMatch)
+	case c.IfMatch != "*" && c.IfMatch != m.ETag:
+		return fail("if-match %q but etag is %q", c.IfMatch, m.ETag)
+	}
+	switch {
+	case c.IfNoneMatch == "" || m == nil:
+	case c.IfNoneMatch == "*":
+		return fail("if-none-match * but exists")
+	case c.IfNoneMatch == m.ETag:
+		return fail("if-none-match %q", c.IfNoneMatch)
+	}
+	switch {
+	case c.IfVersion == "":
+	case m == nil:
+		return fail("if-version %q but missing", c.IfVersion)
+	case c.IfVersion != m.Version:
+		return fail("if-version %q but version is %q", c.IfVersion, m.Version)
+	}
+	return nil
+}
+
+// current metadata for checking conditions, nil if not found
+func statForCondition(ctx context.Context, c StorageCombinator, r Reference) (*Metadata, error) {
+	m, err := Stat(ctx, c, r)
+	if errors.Is(err, NotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// conditions under which content with the given metadata is unchanged
+func unchanged(m *Metadata) Conditions {
+	if m == nil {
+		return CreateOnly
+	}
+	return Conditions{IfMatch: m.ETag}
+}
+
+// first half of a read-modify-write: metadata to compare against later,
+// and whether c supports that at all
+func beginSwap(ctx context.Context, c StorageCombinator, r Reference) (m *Metadata, conditional bool, err error) {
+	m, err = statForCondition(ctx, c, r)
+	if errors.Is(err, NotSupported) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	return m, true, nil
+}
+
+// second half of a read-modify-write: puts only if unchanged since beginSwap,
+// falling back to a plain put for combinators without conditional support
+func endSwap(ctx context.Context, c StorageCombinator, r Reference, i interface{}, m *Metadata, conditional bool) error {
+	if conditional {
+		if err := PutIf(ctx, c, r, i, unchanged(m)); !errors.Is(err, NotSupported) {
+			return err
+		}
+	}
+	return PutContext(ctx, c, r, i)
+}
+
+const MaxConflictRetries = 20
+
+// retries f while it fails with PreconditionFailed,
+// with jittered backoff between attempts
+func retryConflicts(ctx context.Context, f func() error) error {
+	for i := 0; ; i++ {
+		err := f()
+		if i >= MaxConflictRetries || !errors.Is(err, PreconditionFailed) {
+			return err
+		}
+		t := time.NewTimer(time.Duration(rand.Int63n(int64(time.Millisecond << uint(i%8)))))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// wraps precondition failures from various sources
+func wrapPreconditionFailed(r Reference, err error) error {
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case "PreconditionFailed", "ConditionalRequestConflict":
+			err = fmt.Errorf("%w (%s; %v)", PreconditionFailed, aerr.Code(), r)
+		}
+	}
+	return err
+}
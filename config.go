@@ -0,0 +1,346 @@
+package sc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"gopkg.in/yaml.v3"
+)
+
+// Spec declares a combinator, and those it wraps, as a stack which
+// can be written in json or yaml; e.g., a versioned filesystem
+// cached in memory and mounted under /docs:
+//
+//	type: multiplexer
+//	mounts:
+//	- prefix: /docs
+//	  spec:
+//	    type: cache
+//	    options: {ttl: 5m}
+//	    of:
+//	    - type: versioning
+//	      of: [{type: filesystem, options: {path: /var/docs}}]
+//	    - type: memory
+type Spec struct {
+	Type string `json:"type"`
+	// type-specific settings
+	Options map[string]interface{} `json:"options,omitempty"`
+	// combinators wrapped by this one, in the order its factory expects
+	Of []*Spec `json:"of,omitempty"`
+	// for multiplexers
+	Mounts []MountSpec `json:"mounts,omitempty"`
+}
+
+type MountSpec struct {
+	Prefix string `json:"prefix"`
+	Scheme string `json:"scheme,omitempty"`
+	Host   string `json:"host,omitempty"`
+	Strip  bool   `json:"strip,omitempty"`
+	Spec   *Spec  `json:"spec"`
+}
+
+func (s Spec) String() string {
+	buf, _ := json.Marshal(s)
+	return string(buf)
+}
+
+// Decode unmarshals the options into v, usually a pointer to a struct;
+// durations may be given as strings like "5m"
+func (s Spec) Decode(v interface{}) error {
+	buf, err := json.Marshal(s.Options)
+	if err != nil {
+		return err
+	}
+	d := json.NewDecoder(bytes.NewReader(buf))
+	d.DisallowUnknownFields()
+	if err := d.Decode(v); err != nil {
+		return fmt.Errorf("bad options for %q: %w", s.Type, err)
+	}
+	return nil
+}
+
+// Factory builds a combinator from its spec and the already-built
+// combinators of its Of, followed by those of its Mounts
+type Factory func(s *Spec, of []StorageCombinator) (StorageCombinator, error)
+
+var factories = struct {
+	sync.RWMutex
+	m map[string]Factory
+}{m: make(map[string]Factory)}
+
+// RegisterFactory makes a type of combinator available to specs,
+// panicking if the type is already registered
+func RegisterFactory(typ string, f Factory) {
+	factories.Lock()
+	defer factories.Unlock()
+	if _, ok := factories.m[typ]; ok {
+		panic(fmt.Sprintf("factory %q already registered", typ))
+	}
+	factories.m[typ] = f
+}
+
+// Factories returns the registered types
+func Factories() []string {
+	factories.RLock()
+	defer factories.RUnlock()
+	var out []string
+	for k := range factories.m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Build constructs the stack a spec declares, from the bottom up
+func Build(s *Spec) (StorageCombinator, error) {
+	if s == nil {
+		return nil, fmt.Errorf("missing spec")
+	}
+	factories.RLock()
+	f, ok := factories.m[s.Type]
+	factories.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown combinator type %q", s.Type)
+	}
+	var of []StorageCombinator
+	for _, x := range s.Of {
+		c, err := Build(x)
+		if err != nil {
+			return nil, err
+		}
+		of = append(of, c)
+	}
+	for _, m := range s.Mounts {
+		c, err := Build(m.Spec)
+		if err != nil {
+			return nil, fmt.Errorf("mount %q: %w", m.Prefix, err)
+		}
+		of = append(of, c)
+	}
+	c, err := f(s, of)
+	if err != nil {
+		return nil, fmt.Errorf("can't build %q: %w", s.Type, err)
+	}
+	return c, nil
+}
+
+// ParseSpec reads a spec in json, or failing that, yaml
+func ParseSpec(buf []byte) (*Spec, error) {
+	var s Spec
+	if err := json.Unmarshal(buf, &s); err == nil {
+		return &s, nil
+	}
+	var i interface{}
+	if err := yaml.Unmarshal(buf, &i); err != nil {
+		return nil, err
+	}
+	// go through json so there's just one set of field names
+	jbuf, err := json.Marshal(i)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(jbuf, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// LoadSpec reads a spec from a json or yaml file
+func LoadSpec(file string) (*Spec, error) {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSpec(buf)
+}
+
+// a time.Duration which can be given as a string like "5m"
+type specDuration time.Duration
+
+func (d *specDuration) UnmarshalJSON(buf []byte) error {
+	var s string
+	if err := json.Unmarshal(buf, &s); err != nil {
+		var n int64
+		if err := json.Unmarshal(buf, &n); err != nil {
+			return fmt.Errorf("bad duration: %s", buf)
+		}
+		*d = specDuration(n)
+		return nil
+	}
+	x, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = specDuration(x)
+	return nil
+}
+
+func init() {
+	// wraps exactly n combinators
+	wrapper := func(n int, f func(s *Spec, of []StorageCombinator) (StorageCombinator, error)) Factory {
+		return func(s *Spec, of []StorageCombinator) (StorageCombinator, error) {
+			if len(of) != n {
+				return nil, fmt.Errorf("needs %d wrapped combinators, got %d", n, len(of))
+			}
+			return f(s, of)
+		}
+	}
+	noOptions := func(f func(c StorageCombinator) StorageCombinator) Factory {
+		return wrapper(1, func(s *Spec, of []StorageCombinator) (StorageCombinator, error) {
+			if err := s.Decode(&struct{}{}); err != nil {
+				return nil, err
+			}
+			return f(of[0]), nil
+		})
+	}
+	awsSession := func() (*session.Session, error) {
+		return session.NewSessionWithOptions(session.Options{
+			SharedConfigState: session.SharedConfigEnable,
+		})
+	}
+	for k, f := range map[string]Factory{
+		"memory": wrapper(0, func(s *Spec, of []StorageCombinator) (StorageCombinator, error) {
+			if err := s.Decode(&struct{}{}); err != nil {
+				return nil, err
+			}
+			return NewMemory(), nil
+		}),
+		"bounded": wrapper(0, func(s *Spec, of []StorageCombinator) (StorageCombinator, error) {
+			var o struct {
+				MaxEntries int
+				MaxBytes   int64
+				Policy     string
+			}
+			if err := s.Decode(&o); err != nil {
+				return nil, err
+			}
+			opts := BoundedOptions{MaxEntries: o.MaxEntries, MaxBytes: o.MaxBytes}
+			switch strings.ToLower(o.Policy) {
+			case "", "lru":
+				opts.Policy = LRU
+			case "lfu":
+				opts.Policy = LFU
+			default:
+				return nil, fmt.Errorf("unknown eviction policy %q", o.Policy)
+			}
+			return NewBoundedMemory(opts)
+		}),
+		"filesystem": wrapper(0, func(s *Spec, of []StorageCombinator) (StorageCombinator, error) {
+			var o struct{ Path string }
+			if err := s.Decode(&o); err != nil {
+				return nil, err
+			}
+			return NewFileSystem(o.Path)
+		}),
+		"fileappender": wrapper(0, func(s *Spec, of []StorageCombinator) (StorageCombinator, error) {
+			var o struct{ Path string }
+			if err := s.Decode(&o); err != nil {
+				return nil, err
+			}
+			return NewFileAppender(o.Path)
+		}),
+		"s3": wrapper(0, func(s *Spec, of []StorageCombinator) (StorageCombinator, error) {
+			var o struct {
+				Bucket, Prefix string
+				Stream         bool
+				PartSize       int64
+				Concurrency    int
+				Resumable      bool
+			}
+			if err := s.Decode(&o); err != nil {
+				return nil, err
+			}
+			p, err := awsSession()
+			if err != nil {
+				return nil, err
+			}
+			return NewS3KeyValueWithOptions(o.Bucket, o.Prefix, o.Stream, s3.New(p), S3UploadOptions{
+				PartSize:    o.PartSize,
+				Concurrency: o.Concurrency,
+				Resumable:   o.Resumable,
+			})
+		}),
+		"encrypter": wrapper(1, func(s *Spec, of []StorageCombinator) (StorageCombinator, error) {
+			var o struct{ KeyID string }
+			if err := s.Decode(&o); err != nil {
+				return nil, err
+			}
+			p, err := awsSession()
+			if err != nil {
+				return nil, err
+			}
+			return NewEncrypter(kms.New(p), o.KeyID, of[0])
+		}),
+		"versioning":    noOptions(func(c StorageCombinator) StorageCombinator { return NewVersioning(c) }),
+		"appender":      noOptions(func(c StorageCombinator) StorageCombinator { return NewAppender(c) }),
+		"readonly":      noOptions(func(c StorageCombinator) StorageCombinator { return NewReadOnly(c) }),
+		"hashedcontent": noOptions(func(c StorageCombinator) StorageCombinator { return NewHashedContent(c) }),
+		"coalescer":     noOptions(func(c StorageCombinator) StorageCombinator { return NewCoalescer(c) }),
+		"passthrough": wrapper(1, func(s *Spec, of []StorageCombinator) (StorageCombinator, error) {
+			var o struct{ Name string }
+			if err := s.Decode(&o); err != nil {
+				return nil, err
+			}
+			return NewPassthrough(o.Name, of[0]), nil
+		}),
+		"listing": wrapper(1, func(s *Spec, of []StorageCombinator) (StorageCombinator, error) {
+			var o struct{ Ref string }
+			if err := s.Decode(&o); err != nil {
+				return nil, err
+			}
+			r, err := ParseRef(o.Ref)
+			if err != nil {
+				return nil, err
+			}
+			return NewListingCombinator(of[0], r), nil
+		}),
+		"cache": wrapper(2, func(s *Spec, of []StorageCombinator) (StorageCombinator, error) {
+			var o struct {
+				TTL, StaleWhileRevalidate, NegativeTTL specDuration
+				WriteBehind                            bool
+				FlushInterval                          specDuration
+				MaxPending                             int
+				Coalesce                               bool
+			}
+			if err := s.Decode(&o); err != nil {
+				return nil, err
+			}
+			return NewCacheWithOptions(of[0], of[1], CacheOptions{
+				TTL:                  time.Duration(o.TTL),
+				StaleWhileRevalidate: time.Duration(o.StaleWhileRevalidate),
+				NegativeTTL:          time.Duration(o.NegativeTTL),
+				WriteBehind:          o.WriteBehind,
+				FlushInterval:        time.Duration(o.FlushInterval),
+				MaxPending:           o.MaxPending,
+				Coalesce:             o.Coalesce,
+			}), nil
+		}),
+		"multiplexer": func(s *Spec, of []StorageCombinator) (StorageCombinator, error) {
+			if len(s.Of) > 0 || len(of) != len(s.Mounts) {
+				return nil, fmt.Errorf("needs mounts only")
+			}
+			var mounts []Mount
+			for i, m := range s.Mounts {
+				mounts = append(mounts, Mount{
+					Prefix: m.Prefix,
+					Scheme: m.Scheme,
+					Host:   m.Host,
+					Strip:  m.Strip,
+					C:      of[i],
+				})
+			}
+			return NewMountTable(mounts...)
+		},
+	} {
+		RegisterFactory(k, f)
+	}
+}
@@ -0,0 +1,211 @@
+package sc
+
+import (
+	"context"
+	"io"
+)
+
+// ContextCombinator is a storage combinator whose methods honor
+// cancellation and deadlines of the given context
+type ContextCombinator interface {
+	GetContext(context.Context, Reference) (interface{}, error)
+	PutContext(context.Context, Reference, interface{}) error
+	DeleteContext(context.Context, Reference) error
+	MergeContext(context.Context, Reference, interface{}) error
+}
+
+// GetContext calls c's context-aware Get if it has one,
+// otherwise checks the context before calling plain Get
+func GetContext(ctx context.Context, c StorageCombinator, r Reference) (interface{}, error) {
+	if cc, ok := c.(ContextCombinator); ok {
+		return cc.GetContext(ctx, r)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.Get(r)
+}
+
+// PutContext is like GetContext, but for Put
+func PutContext(ctx context.Context, c StorageCombinator, r Reference, i interface{}) error {
+	if cc, ok := c.(ContextCombinator); ok {
+		return cc.PutContext(ctx, r, i)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.Put(r, i)
+}
+
+// DeleteContext is like GetContext, but for Delete
+func DeleteContext(ctx context.Context, c StorageCombinator, r Reference) error {
+	if cc, ok := c.(ContextCombinator); ok {
+		return cc.DeleteContext(ctx, r)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.Delete(r)
+}
+
+// MergeContext is like GetContext, but for Merge
+func MergeContext(ctx context.Context, c StorageCombinator, r Reference, i interface{}) error {
+	if cc, ok := c.(ContextCombinator); ok {
+		return cc.MergeContext(ctx, r, i)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.Merge(r, i)
+}
+
+// signature shared by PutContext and MergeContext
+type contextMutator func(context.Context, StorageCombinator, Reference, interface{}) error
+
+// WithContext adapts any storage combinator to the context-aware interface;
+// combinators that are already context-aware are returned as-is
+func WithContext(c StorageCombinator) ContextCombinator {
+	if cc, ok := c.(ContextCombinator); ok {
+		return cc
+	}
+	return contextAdapter{c: c}
+}
+
+type contextAdapter struct {
+	c StorageCombinator
+}
+
+func (a contextAdapter) GetContext(ctx context.Context, r Reference) (interface{}, error) {
+	return GetContext(ctx, a.c, r)
+}
+
+func (a contextAdapter) PutContext(ctx context.Context, r Reference, i interface{}) error {
+	return PutContext(ctx, a.c, r, i)
+}
+
+func (a contextAdapter) DeleteContext(ctx context.Context, r Reference) error {
+	return DeleteContext(ctx, a.c, r)
+}
+
+func (a contextAdapter) MergeContext(ctx context.Context, r Reference, i interface{}) error {
+	return MergeContext(ctx, a.c, r, i)
+}
+
+func (a contextAdapter) List(ctx context.Context, prefix Reference, opts ListOptions) (*ListPage, error) {
+	return List(ctx, a.c, prefix, opts)
+}
+
+func (a contextAdapter) Stat(ctx context.Context, r Reference) (*Metadata, error) {
+	return Stat(ctx, a.c, r)
+}
+
+func (a contextAdapter) PutIf(ctx context.Context, r Reference, i interface{}, cond Conditions) error {
+	return PutIf(ctx, a.c, r, i, cond)
+}
+
+func (a contextAdapter) DeleteIf(ctx context.Context, r Reference, cond Conditions) error {
+	return DeleteIf(ctx, a.c, r, cond)
+}
+
+func (a contextAdapter) GetStream(ctx context.Context, r Reference) (io.ReadCloser, error) {
+	return GetStream(ctx, a.c, r)
+}
+
+// BindContext adapts a context-aware combinator to a plain StorageCombinator,
+// using the given context for every call
+func BindContext(ctx context.Context, c ContextCombinator) *BoundContext {
+	return &BoundContext{ctx: ctx, c: c}
+}
+
+type BoundContext struct {
+	ctx context.Context
+	c   ContextCombinator
+}
+
+func (b BoundContext) Get(r Reference) (interface{}, error) {
+	return b.c.GetContext(b.ctx, r)
+}
+
+func (b BoundContext) Put(r Reference, i interface{}) error {
+	return b.c.PutContext(b.ctx, r, i)
+}
+
+func (b BoundContext) Delete(r Reference) error {
+	return b.c.DeleteContext(b.ctx, r)
+}
+
+func (b BoundContext) Merge(r Reference, i interface{}) error {
+	return b.c.MergeContext(b.ctx, r, i)
+}
+
+func (b BoundContext) GetContext(ctx context.Context, r Reference) (interface{}, error) {
+	return b.c.GetContext(ctx, r)
+}
+
+func (b BoundContext) PutContext(ctx context.Context, r Reference, i interface{}) error {
+	return b.c.PutContext(ctx, r, i)
+}
+
+func (b BoundContext) DeleteContext(ctx context.Context, r Reference) error {
+	return b.c.DeleteContext(ctx, r)
+}
+
+func (b BoundContext) MergeContext(ctx context.Context, r Reference, i interface{}) error {
+	return b.c.MergeContext(ctx, r, i)
+}
+
+func (b BoundContext) List(ctx context.Context, prefix Reference, opts ListOptions) (*ListPage, error) {
+	l, ok := b.c.(Lister)
+	if !ok {
+		return nil, unsupported(b.c, "List")
+	}
+	return l.List(ctx, prefix, opts)
+}
+
+func (b BoundContext) Stat(ctx context.Context, r Reference) (*Metadata, error) {
+	s, ok := b.c.(Stater)
+	if !ok {
+		return nil, unsupported(b.c, "Stat")
+	}
+	return s.Stat(ctx, r)
+}
+
+func (b BoundContext) PutIf(ctx context.Context, r Reference, i interface{}, cond Conditions) error {
+	cc, ok := b.c.(ConditionalCombinator)
+	if !ok {
+		return unsupported(b.c, "PutIf")
+	}
+	return cc.PutIf(ctx, r, i, cond)
+}
+
+func (b BoundContext) DeleteIf(ctx context.Context, r Reference, cond Conditions) error {
+	cc, ok := b.c.(ConditionalCombinator)
+	if !ok {
+		return unsupported(b.c, "DeleteIf")
+	}
+	return cc.DeleteIf(ctx, r, cond)
+}
+
+func (b BoundContext) GetStream(ctx context.Context, r Reference) (io.ReadCloser, error) {
+	if s, ok := b.c.(StreamGetter); ok {
+		return s.GetStream(ctx, r)
+	}
+	i, err := b.c.GetContext(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	return Reader(i)
+}
+
+// reader that fails once its context is done, for long copies
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
@@ -2,6 +2,7 @@ package sc
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/base64"
 	"encoding/csv"
@@ -27,6 +28,10 @@ func NewDatabaseCombinator(db *sql.DB) *DatabaseCombinator {
 // interface: string/reader/bytes, defaults to bytes
 // header: true or false, defaults to true, whether to output a header row (metadata)
 func (dc DatabaseCombinator) Get(r Reference) (interface{}, error) {
+	return dc.GetContext(context.Background(), r)
+}
+
+func (dc DatabaseCombinator) GetContext(ctx context.Context, r Reference) (interface{}, error) {
 	proc := func(key string, required bool, defaultValue string) (string, error) {
 		q := r.URI().Query()
 		v, ok := q[key]
@@ -74,7 +79,7 @@ func (dc DatabaseCombinator) Get(r Reference) (interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
-	rows, err := dc.db.Query(query)
+	rows, err := dc.db.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -236,14 +241,26 @@ func (dc DatabaseCombinator) Get(r Reference) (interface{}, error) {
 	}
 }
 
-func (dc DatabaseCombinator) Put(Reference, interface{}) error {
+func (dc DatabaseCombinator) Put(r Reference, i interface{}) error {
+	return dc.PutContext(context.Background(), r, i)
+}
+
+func (dc DatabaseCombinator) PutContext(context.Context, Reference, interface{}) error {
 	return unsupported(dc, "Put")
 }
 
-func (dc DatabaseCombinator) Delete(Reference) error {
+func (dc DatabaseCombinator) Delete(r Reference) error {
+	return dc.DeleteContext(context.Background(), r)
+}
+
+func (dc DatabaseCombinator) DeleteContext(context.Context, Reference) error {
 	return unsupported(dc, "Delete")
 }
 
-func (dc DatabaseCombinator) Merge(Reference, interface{}) error {
+func (dc DatabaseCombinator) Merge(r Reference, i interface{}) error {
+	return dc.MergeContext(context.Background(), r, i)
+}
+
+func (dc DatabaseCombinator) MergeContext(context.Context, Reference, interface{}) error {
 	return unsupported(dc, "Merge")
 }
@@ -1,6 +1,10 @@
 package sc
 
-import "sync"
+import (
+	"context"
+	"io"
+	"sync"
+)
 
 type Deferred struct {
 	factory func() (StorageCombinator, error)
@@ -30,29 +34,80 @@ func (d *Deferred) Init() error {
 }
 
 func (d *Deferred) Get(r Reference) (interface{}, error) {
+	return d.GetContext(context.Background(), r)
+}
+
+func (d *Deferred) GetContext(ctx context.Context, r Reference) (interface{}, error) {
 	if err := d.Init(); err != nil {
 		return nil, err
 	}
-	return d.c.Get(r)
+	return GetContext(ctx, d.c, r)
 }
 
 func (d *Deferred) Put(r Reference, i interface{}) error {
+	return d.PutContext(context.Background(), r, i)
+}
+
+func (d *Deferred) PutContext(ctx context.Context, r Reference, i interface{}) error {
 	if err := d.Init(); err != nil {
 		return err
 	}
-	return d.c.Put(r, i)
+	return PutContext(ctx, d.c, r, i)
 }
 
 func (d *Deferred) Delete(r Reference) error {
+	return d.DeleteContext(context.Background(), r)
+}
+
+func (d *Deferred) DeleteContext(ctx context.Context, r Reference) error {
 	if err := d.Init(); err != nil {
 		return err
 	}
-	return d.c.Delete(r)
+	return DeleteContext(ctx, d.c, r)
 }
 
 func (d *Deferred) Merge(r Reference, i interface{}) error {
+	return d.MergeContext(context.Background(), r, i)
+}
+
+func (d *Deferred) MergeContext(ctx context.Context, r Reference, i interface{}) error {
 	if err := d.Init(); err != nil {
 		return err
 	}
-	return d.c.Merge(r, i)
+	return MergeContext(ctx, d.c, r, i)
+}
+
+func (d *Deferred) List(ctx context.Context, prefix Reference, opts ListOptions) (*ListPage, error) {
+	if err := d.Init(); err != nil {
+		return nil, err
+	}
+	return List(ctx, d.c, prefix, opts)
+}
+
+func (d *Deferred) Stat(ctx context.Context, r Reference) (*Metadata, error) {
+	if err := d.Init(); err != nil {
+		return nil, err
+	}
+	return Stat(ctx, d.c, r)
+}
+
+func (d *Deferred) PutIf(ctx context.Context, r Reference, i interface{}, cond Conditions) error {
+	if err := d.Init(); err != nil {
+		return err
+	}
+	return PutIf(ctx, d.c, r, i, cond)
+}
+
+func (d *Deferred) DeleteIf(ctx context.Context, r Reference, cond Conditions) error {
+	if err := d.Init(); err != nil {
+		return err
+	}
+	return DeleteIf(ctx, d.c, r, cond)
+}
+
+func (d *Deferred) GetStream(ctx context.Context, r Reference) (io.ReadCloser, error) {
+	if err := d.Init(); err != nil {
+		return nil, err
+	}
+	return GetStream(ctx, d.c, r)
 }
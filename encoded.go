@@ -1,5 +1,7 @@
 package sc
 
+import "context"
+
 type EncodedRefs struct {
 	c StorageCombinator
 }
@@ -13,33 +15,49 @@ func encode(r Reference) (Reference, error) {
 }
 
 func (e EncodedRefs) Get(r Reference) (interface{}, error) {
+	return e.GetContext(context.Background(), r)
+}
+
+func (e EncodedRefs) GetContext(ctx context.Context, r Reference) (interface{}, error) {
 	er, err := encode(r)
 	if err != nil {
 		return nil, err
 	}
-	return e.c.Get(er)
+	return GetContext(ctx, e.c, er)
 }
 
 func (e EncodedRefs) Put(r Reference, i interface{}) error {
+	return e.PutContext(context.Background(), r, i)
+}
+
+func (e EncodedRefs) PutContext(ctx context.Context, r Reference, i interface{}) error {
 	er, err := encode(r)
 	if err != nil {
 		return err
 	}
-	return e.c.Put(er, i)
+	return PutContext(ctx, e.c, er, i)
 }
 
 func (e EncodedRefs) Delete(r Reference) error {
+	return e.DeleteContext(context.Background(), r)
+}
+
+func (e EncodedRefs) DeleteContext(ctx context.Context, r Reference) error {
 	er, err := encode(r)
 	if err != nil {
 		return err
 	}
-	return e.c.Delete(er)
+	return DeleteContext(ctx, e.c, er)
 }
 
 func (e EncodedRefs) Merge(r Reference, i interface{}) error {
+	return e.MergeContext(context.Background(), r, i)
+}
+
+func (e EncodedRefs) MergeContext(ctx context.Context, r Reference, i interface{}) error {
 	er, err := encode(r)
 	if err != nil {
 		return err
 	}
-	return e.c.Merge(er, i)
+	return MergeContext(ctx, e.c, er, i)
 }
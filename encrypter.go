@@ -2,12 +2,14 @@ package sc
 
 import (
 	"bytes"
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
 	"encoding/binary"
 	"fmt"
 	"io"
+	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/kms"
@@ -33,11 +35,16 @@ func NewEncrypter(svc *kms.KMS, keyID string, c StorageCombinator) (*Encrypter,
 		c:     c,
 	}
 	const test = "hello world"
-	enc, err := e.encrypt([]byte(test))
+	ctx := context.Background()
+	enc, err := e.encrypt(ctx, strings.NewReader(test))
 	if err != nil {
 		return nil, err
 	}
-	dec, err := e.decrypt(enc)
+	rc, err := e.decrypt(ctx, enc)
+	if err != nil {
+		return nil, err
+	}
+	dec, err := Blob(rc)
 	if err != nil {
 		return nil, err
 	}
@@ -48,47 +55,77 @@ func NewEncrypter(svc *kms.KMS, keyID string, c StorageCombinator) (*Encrypter,
 }
 
 func (e Encrypter) Get(r Reference) (interface{}, error) {
-	i, err := e.c.Get(r)
+	return e.GetContext(context.Background(), r)
+}
+
+func (e Encrypter) GetContext(ctx context.Context, r Reference) (interface{}, error) {
+	rc, err := e.GetStream(ctx, r)
 	if err != nil {
 		return nil, err
 	}
-	enc, err := Blob(i)
+	return Blob(rc)
+}
+
+// decrypts incrementally, unless content is in the original non-streaming format
+func (e Encrypter) GetStream(ctx context.Context, r Reference) (io.ReadCloser, error) {
+	rc, err := GetStream(ctx, e.c, r)
 	if err != nil {
 		return nil, err
 	}
-	dec, err := e.decrypt(enc)
+	dec, err := e.decrypt(ctx, rc)
 	if err != nil {
+		rc.Close()
 		return nil, err
 	}
-	return dec, nil
+	return multiCloser{Reader: dec, closers: []io.Closer{rc}}, nil
 }
 
 func (e Encrypter) Put(r Reference, i interface{}) error {
-	return e.update(r, i, e.c.Put)
+	return e.PutContext(context.Background(), r, i)
+}
+
+func (e Encrypter) PutContext(ctx context.Context, r Reference, i interface{}) error {
+	return e.update(ctx, r, i, PutContext)
 }
 
 func (e Encrypter) Delete(r Reference) error {
-	return e.c.Delete(r)
+	return e.DeleteContext(context.Background(), r)
+}
+
+func (e Encrypter) DeleteContext(ctx context.Context, r Reference) error {
+	return DeleteContext(ctx, e.c, r)
 }
 
 func (e Encrypter) Merge(r Reference, i interface{}) error {
-	return e.update(r, i, e.c.Merge)
+	return e.MergeContext(context.Background(), r, i)
+}
+
+func (e Encrypter) MergeContext(ctx context.Context, r Reference, i interface{}) error {
+	return e.update(ctx, r, i, MergeContext)
 }
 
-func (e Encrypter) update(r Reference, i interface{}, f func(Reference, interface{}) error) error {
-	buf, err := Blob(i)
+func (e Encrypter) update(ctx context.Context, r Reference, i interface{}, f contextMutator) error {
+	rc, err := Reader(i)
 	if err != nil {
 		return err
 	}
-	enc, err := e.encrypt(buf)
+	defer rc.Close()
+	enc, err := e.encrypt(ctx, rc)
 	if err != nil {
 		return err
 	}
-	return f(r, enc)
+	defer enc.Close()
+	return f(ctx, e.c, r, enc)
 }
 
-func (e Encrypter) encrypt(data []byte) ([]byte, error) {
-	ko, err := e.svc.GenerateDataKey(&kms.GenerateDataKeyInput{
+// leading marker of the streaming format; the original format
+// begins with the (positive) length of the encrypted data key instead
+const streamFormat int64 = -1
+
+// encrypts a stream under a fresh data key, in the streaming format:
+// marker, length of encrypted data key, encrypted data key, then EncryptStream output
+func (e Encrypter) encrypt(ctx context.Context, src io.Reader) (io.ReadCloser, error) {
+	ko, err := e.svc.GenerateDataKeyWithContext(ctx, &kms.GenerateDataKeyInput{
 		KeyId:   aws.String(e.keyID),
 		KeySpec: aws.String(Algo),
 	})
@@ -98,35 +135,41 @@ func (e Encrypter) encrypt(data []byte) ([]byte, error) {
 	if n := len(ko.Plaintext); n != KeyLength {
 		return nil, fmt.Errorf("got %d bytes, expected %d", n, KeyLength)
 	}
-	enc, err := Encrypt(data, ko.Plaintext)
-	if err != nil {
+	w := new(bytes.Buffer)
+	if err := binary.Write(w, binary.BigEndian, streamFormat); err != nil {
 		return nil, err
 	}
-	w := new(bytes.Buffer)
-	n := int64(len(ko.CiphertextBlob))
-	if err := binary.Write(w, binary.BigEndian, n); err != nil {
+	if err := binary.Write(w, binary.BigEndian, int64(len(ko.CiphertextBlob))); err != nil {
 		return nil, err
 	}
 	w.Write(ko.CiphertextBlob)
-	w.Write(enc)
-	return w.Bytes(), nil
+	enc, err := EncryptStream(contextReader{ctx: ctx, r: src}, ko.Plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return multiCloser{Reader: io.MultiReader(w, enc), closers: []io.Closer{enc}}, nil
 }
 
-func (e Encrypter) decrypt(in []byte) ([]byte, error) {
-	r := bytes.NewReader(in)
+// decrypts either format
+func (e Encrypter) decrypt(ctx context.Context, r io.Reader) (io.Reader, error) {
 	var n int64
 	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
 		return nil, err
 	}
-	key := make([]byte, n)
-	x, err := r.Read(key)
-	if err != nil {
-		return nil, err
+	streaming := n == streamFormat
+	if streaming {
+		if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+			return nil, err
+		}
+	}
+	if n <= 0 || n > 1<<16 {
+		return nil, fmt.Errorf("bad key size: %d", n)
 	}
-	if x != int(n) {
-		return nil, fmt.Errorf("key size mismatch: %d vs %d", x, n)
+	key := make([]byte, n)
+	if _, err := io.ReadFull(r, key); err != nil {
+		return nil, fmt.Errorf("key size mismatch: %w", err)
 	}
-	o, err := e.svc.Decrypt(&kms.DecryptInput{
+	o, err := e.svc.DecryptWithContext(ctx, &kms.DecryptInput{
 		CiphertextBlob: key,
 	})
 	if err != nil {
@@ -135,11 +178,18 @@ func (e Encrypter) decrypt(in []byte) ([]byte, error) {
 	if n := len(o.Plaintext); n != KeyLength {
 		return nil, fmt.Errorf("got %d bytes, expected %d", n, KeyLength)
 	}
+	if streaming {
+		return DecryptStream(contextReader{ctx: ctx, r: r}, o.Plaintext)
+	}
 	w := new(bytes.Buffer)
-	if _, err := io.Copy(w, r); err != nil {
+	if _, err := io.Copy(w, contextReader{ctx: ctx, r: r}); err != nil {
+		return nil, err
+	}
+	dec, err := Decrypt(w.Bytes(), o.Plaintext)
+	if err != nil {
 		return nil, err
 	}
-	return Decrypt(w.Bytes(), o.Plaintext)
+	return bytes.NewReader(dec), nil
 }
 
 func Encrypt(data, key []byte) ([]byte, error) {
@@ -173,3 +223,150 @@ func Decrypt(data, key []byte) ([]byte, error) {
 	}
 	return plaintext, nil
 }
+
+// entry sizes are those of the encrypted content
+func (e Encrypter) List(ctx context.Context, prefix Reference, opts ListOptions) (*ListPage, error) {
+	return List(ctx, e.c, prefix, opts)
+}
+
+// plaintext size of each segment of the streaming format
+const SegmentSize = 64 * 1024
+
+// marks the last segment, in both the length prefix and additional data
+const finalSegment = 1 << 31
+
+// EncryptStream encrypts with AES-GCM in independently sealed segments,
+// each prefixed by its length and sealed with a nonce from its sequence
+// number; the last segment is flagged so truncation is detectable.
+// the key must be used for only one stream
+func EncryptStream(src io.Reader, key []byte) (io.ReadCloser, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(func() error {
+			read := func(buf []byte) (int, bool, error) {
+				n, err := io.ReadFull(src, buf)
+				switch err {
+				case nil:
+					return n, false, nil
+				case io.EOF, io.ErrUnexpectedEOF:
+					return n, true, nil
+				default:
+					return 0, false, err
+				}
+			}
+			cur, next := make([]byte, SegmentSize), make([]byte, SegmentSize)
+			n, eof, err := read(cur)
+			if err != nil {
+				return err
+			}
+			for seq := uint64(0); ; seq++ {
+				final := eof
+				var m int
+				var nextEOF bool
+				if !final {
+					m, nextEOF, err = read(next)
+					if err != nil {
+						return err
+					}
+					// a full segment followed by nothing is the last one
+					final = m == 0 && nextEOF
+				}
+				if _, err := pw.Write(sealSegment(gcm, seq, final, cur[:n])); err != nil {
+					return err
+				}
+				if final {
+					return nil
+				}
+				cur, next, n, eof = next, cur, m, nextEOF
+			}
+		}())
+	}()
+	return pr, nil
+}
+
+func sealSegment(gcm cipher.AEAD, seq uint64, final bool, plaintext []byte) []byte {
+	header := make([]byte, 4)
+	flag := segmentFlag(final)
+	out := gcm.Seal(header, segmentNonce(gcm, seq), plaintext, flag)
+	length := uint32(len(out) - len(header))
+	if final {
+		length |= finalSegment
+	}
+	binary.BigEndian.PutUint32(out, length)
+	return out
+}
+
+func segmentNonce(gcm cipher.AEAD, seq uint64) []byte {
+	nonce := make([]byte, gcm.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], seq)
+	return nonce
+}
+
+func segmentFlag(final bool) []byte {
+	if final {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// DecryptStream reverses EncryptStream, failing on tampering or truncation
+func DecryptStream(src io.Reader, key []byte) (io.Reader, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptingReader{src: src, gcm: gcm}, nil
+}
+
+type decryptingReader struct {
+	src     io.Reader
+	gcm     cipher.AEAD
+	seq     uint64
+	pending []byte
+	done    bool
+}
+
+func (d *decryptingReader) Read(p []byte) (int, error) {
+	for len(d.pending) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		var length uint32
+		if err := binary.Read(d.src, binary.BigEndian, &length); err == io.EOF {
+			return 0, fmt.Errorf("truncated encrypted stream")
+		} else if err != nil {
+			return 0, err
+		}
+		final := length&finalSegment != 0
+		length &^= finalSegment
+		if length > SegmentSize+uint32(d.gcm.Overhead()) {
+			return 0, fmt.Errorf("bad segment length: %d", length)
+		}
+		sealed := make([]byte, length)
+		if _, err := io.ReadFull(d.src, sealed); err != nil {
+			return 0, fmt.Errorf("truncated encrypted stream: %w", err)
+		}
+		plaintext, err := d.gcm.Open(nil, segmentNonce(d.gcm, d.seq), sealed, segmentFlag(final))
+		if err != nil {
+			return 0, err
+		}
+		d.seq++
+		d.pending = plaintext
+		d.done = final
+	}
+	n := copy(p, d.pending)
+	d.pending = d.pending[n:]
+	return n, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -1,11 +1,15 @@
 package sc
 
 import (
+	"bytes"
+	"context"
 	"crypto/md5"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 // will simply append data upon Merge call
@@ -39,6 +43,13 @@ func (ac AppendingCombinator) Find(p string) (Reference, error) {
 }
 
 func (ac AppendingCombinator) Get(r Reference) (interface{}, error) {
+	return ac.GetContext(context.Background(), r)
+}
+
+func (ac AppendingCombinator) GetContext(ctx context.Context, r Reference) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	buf, err := ioutil.ReadFile(ac.file(r))
 	if err != nil {
 		return nil, wrapNotFound(r, err)
@@ -47,45 +58,66 @@ func (ac AppendingCombinator) Get(r Reference) (interface{}, error) {
 }
 
 func (ac AppendingCombinator) Put(r Reference, i interface{}) error {
+	return ac.PutContext(context.Background(), r, i)
+}
+
+func (ac AppendingCombinator) PutContext(ctx context.Context, r Reference, i interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	file := ac.file(r)
 	if err := mkdir(filepath.Dir(file)); err != nil {
 		return err
 	}
-	var buf []byte
+	return ac.write(ctx, file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, i)
+}
+
+// writes bytes, strings or streams
+func (ac AppendingCombinator) write(ctx context.Context, file string, flags int, i interface{}) error {
+	var r io.Reader
 	switch t := i.(type) {
 	case []byte:
-		buf = t
+		r = bytes.NewReader(t)
 	case string:
-		buf = []byte(t)
+		r = strings.NewReader(t)
+	case io.Reader:
+		if c, ok := t.(io.Closer); ok {
+			defer c.Close()
+		}
+		r = t
 	default:
 		return fmt.Errorf("unsupported type: %T", t)
 	}
-	return ioutil.WriteFile(file, buf, os.ModePerm)
+	f, err := os.OpenFile(file, flags, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, contextReader{ctx: ctx, r: r}); err != nil {
+		return err
+	}
+	return f.Close()
 }
 
 func (ac AppendingCombinator) Delete(r Reference) error {
+	return ac.DeleteContext(context.Background(), r)
+}
+
+func (ac AppendingCombinator) DeleteContext(ctx context.Context, r Reference) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	return os.RemoveAll(ac.file(r))
 }
 
 // simple appends or creates
 func (ac AppendingCombinator) Merge(r Reference, i interface{}) error {
-	f, err := os.OpenFile(ac.file(r), os.O_APPEND|os.O_WRONLY|os.O_CREATE, os.ModePerm)
-	if err != nil {
-		fmt.Println("***", ac.file(r))
-		return err
-	}
-	defer f.Close()
-	var buf []byte
-	switch t := i.(type) {
-	case []byte:
-		buf = t
-	case string:
-		buf = []byte(t)
-	default:
-		return fmt.Errorf("unsupported type: %T", t)
-	}
-	if _, err := f.Write(buf); err != nil {
+	return ac.MergeContext(context.Background(), r, i)
+}
+
+func (ac AppendingCombinator) MergeContext(ctx context.Context, r Reference, i interface{}) error {
+	if err := ctx.Err(); err != nil {
 		return err
 	}
-	return nil
+	return ac.write(ctx, ac.file(r), os.O_APPEND|os.O_WRONLY|os.O_CREATE, i)
 }
@@ -2,6 +2,7 @@ package sc
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -12,7 +13,10 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/google/uuid"
 )
 
 // NewFileSystem creates a new filesystem storage combinator with
@@ -90,6 +94,13 @@ func (f FileReference) String() string {
 }
 
 func (fs FileSystem) Get(r Reference) (interface{}, error) {
+	return fs.GetContext(context.Background(), r)
+}
+
+func (fs FileSystem) GetContext(ctx context.Context, r Reference) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	p, err := fs.path(r)
 	if err != nil {
 		return nil, err
@@ -113,10 +124,17 @@ func (fs FileSystem) Get(r Reference) (interface{}, error) {
 }
 
 func (fs FileSystem) Put(r Reference, i interface{}) error {
-	return fs.put(r, i, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	return fs.PutContext(context.Background(), r, i)
+}
+
+func (fs FileSystem) PutContext(ctx context.Context, r Reference, i interface{}) error {
+	return fs.put(ctx, r, i, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
 }
 
-func (fs FileSystem) put(r Reference, i interface{}, flags int) error {
+func (fs FileSystem) put(ctx context.Context, r Reference, i interface{}, flags int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	p, err := fs.path(r)
 	if err != nil {
 		return err
@@ -125,15 +143,40 @@ func (fs FileSystem) put(r Reference, i interface{}, flags int) error {
 		return err
 	}
 	write := func(reader io.Reader) error {
-		file, err := os.OpenFile(p, flags, 0666)
+		if flags&os.O_TRUNC == 0 {
+			file, err := os.OpenFile(p, flags, 0666)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			if _, err := io.Copy(file, contextReader{ctx: ctx, r: reader}); err != nil {
+				return err
+			}
+			return file.Close()
+		}
+		// replace via a temporary file, so concurrent readers,
+		// including one streaming into this very put, see old content.
+		// it's created 0666 less the umask, as the file itself would be
+		name := filepath.Join(filepath.Dir(p), "."+filepath.Base(p)+".tmp"+uuid.New().String())
+		tmp, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
 		if err != nil {
 			return err
 		}
-		defer file.Close()
-		if _, err := io.Copy(file, reader); err != nil {
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+		if _, err := io.Copy(tmp, contextReader{ctx: ctx, r: reader}); err != nil {
+			return err
+		}
+		// but a file being replaced keeps its mode
+		if fi, err := os.Stat(p); err == nil && fi.Mode().IsRegular() {
+			if err := tmp.Chmod(fi.Mode().Perm()); err != nil {
+				return err
+			}
+		}
+		if err := tmp.Close(); err != nil {
 			return err
 		}
-		return file.Close()
+		return os.Rename(tmp.Name(), p)
 	}
 	var reader io.Reader
 	close := func() error {
@@ -144,13 +187,13 @@ func (fs FileSystem) put(r Reference, i interface{}, flags int) error {
 		reader = bytes.NewReader(t)
 	case string:
 		reader = strings.NewReader(t)
-	case io.Reader:
-		reader = t
 	case io.ReadCloser:
 		reader = t
 		close = func() error {
 			return t.Close()
 		}
+	case io.Reader:
+		reader = t
 	default:
 		reader = strings.NewReader(fmt.Sprint(t))
 	}
@@ -160,7 +203,38 @@ func (fs FileSystem) put(r Reference, i interface{}, flags int) error {
 	return close()
 }
 
+func (fs FileSystem) GetStream(ctx context.Context, r Reference) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	p, err := fs.path(r)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, wrapNotFound(r, err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if fi.IsDir() {
+		f.Close()
+		return nil, fmt.Errorf("can't stream directory %v", r)
+	}
+	return multiCloser{Reader: contextReader{ctx: ctx, r: f}, closers: []io.Closer{f}}, nil
+}
+
 func (fs FileSystem) Delete(r Reference) error {
+	return fs.DeleteContext(context.Background(), r)
+}
+
+func (fs FileSystem) DeleteContext(ctx context.Context, r Reference) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	p, err := fs.path(r)
 	if err != nil {
 		return err
@@ -170,5 +244,137 @@ func (fs FileSystem) Delete(r Reference) error {
 
 // appends to the file or creates it
 func (fs FileSystem) Merge(r Reference, i interface{}) error {
-	return fs.put(r, i, os.O_WRONLY|os.O_CREATE|os.O_APPEND)
+	return fs.MergeContext(context.Background(), r, i)
+}
+
+func (fs FileSystem) MergeContext(ctx context.Context, r Reference, i interface{}) error {
+	return fs.put(ctx, r, i, os.O_WRONLY|os.O_CREATE|os.O_APPEND)
+}
+
+func (fs FileSystem) List(ctx context.Context, prefix Reference, opts ListOptions) (*ListPage, error) {
+	dir := listPath(prefix.URI().Path)
+	root := filepath.Join(fs.mount, filepath.FromSlash(dir))
+	var entries []Entry
+	add := func(p string, fi os.FileInfo) {
+		entries = append(entries, Entry{
+			Path:    p,
+			Size:    fi.Size(),
+			ModTime: fi.ModTime(),
+			IsDir:   fi.IsDir(),
+		})
+	}
+	if opts.Recursive {
+		if err := filepath.Walk(root, func(file string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(fs.mount, file)
+			if err != nil {
+				return err
+			}
+			add(listPath(filepath.ToSlash(rel)), fi)
+			return nil
+		}); err != nil {
+			return nil, wrapNotFound(prefix, err)
+		}
+	} else {
+		list, err := ioutil.ReadDir(root)
+		if err != nil {
+			return nil, wrapNotFound(prefix, err)
+		}
+		for _, fi := range list {
+			add(path.Join(dir, fi.Name()), fi)
+		}
+	}
+	return paginate(dir, entries, opts), nil
+}
+
+func (fs FileSystem) Stat(ctx context.Context, r Reference) (*Metadata, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	p, err := fs.path(r)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(p)
+	if err != nil {
+		return nil, wrapNotFound(r, err)
+	}
+	m := Metadata{
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+		IsDir:   fi.IsDir(),
+	}
+	if fi.IsDir() {
+		return &m, nil
+	}
+	m.ContentType = contentType(p)
+	m.ETag = fileETag(fi)
+	return &m, nil
+}
+
+// changes whenever the file is rewritten, without reading it
+func fileETag(fi os.FileInfo) string {
+	return fmt.Sprintf("%x-%x-%x", fi.Size(), fi.ModTime().UnixNano(), fileID(fi))
+}
+
+// the md5 of a file's content, for when etags won't do
+func (fs FileSystem) contentMD5(ctx context.Context, r Reference) (string, error) {
+	p, err := fs.path(r)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return "", wrapNotFound(r, err)
+	}
+	defer f.Close()
+	return etag(contextReader{ctx: ctx, r: f})
+}
+
+// serializes conditional operations within this process, per mount
+var fsLocks sync.Map
+
+func (fs FileSystem) lock() func() {
+	x, _ := fsLocks.LoadOrStore(fs.mount, new(sync.Mutex))
+	m := x.(*sync.Mutex)
+	m.Lock()
+	return m.Unlock
+}
+
+// conditions are checked atomically with respect to other conditional
+// operations in this process, and create-only puts are atomic across processes
+func (fs FileSystem) PutIf(ctx context.Context, r Reference, i interface{}, cond Conditions) error {
+	defer fs.lock()()
+	m, err := statForCondition(ctx, fs, r)
+	if err != nil {
+		return err
+	}
+	if err := cond.check(r, m); err != nil {
+		return err
+	}
+	if m == nil && cond.IfNoneMatch == "*" {
+		err := fs.put(ctx, r, i, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+		if errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("%w (created concurrently; %v)", PreconditionFailed, r)
+		}
+		return err
+	}
+	return fs.PutContext(ctx, r, i)
+}
+
+func (fs FileSystem) DeleteIf(ctx context.Context, r Reference, cond Conditions) error {
+	defer fs.lock()()
+	m, err := statForCondition(ctx, fs, r)
+	if err != nil {
+		return err
+	}
+	if err := cond.check(r, m); err != nil {
+		return err
+	}
+	return fs.DeleteContext(ctx, r)
 }
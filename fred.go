@@ -2,6 +2,7 @@ package sc
 
 import (
 	"bytes"
+	"context"
 	"encoding/csv"
 	"encoding/json"
 	"encoding/xml"
@@ -9,34 +10,198 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 )
 
+const FredBaseURL = "https://api.stlouisfed.org/fred/"
+
+// FredEngine reads from the fred and alfred apis, with references either
+// full api urls, or paths routed to endpoints:
+//
+//	/series/ID                  series info
+//	/series/ID/observations     observations
+//	/series/ID/vintages         dates of alfred vintages
+//	/category/ID                category info
+//	/category/ID/children       subcategories
+//	/category/ID/series         series in a category
+//	/search?search_text=...     series search
+//
+// other query parameters are passed on, so realtime_start, realtime_end
+// and vintage_dates select alfred vintages. responses are json unless
+// file_type=xml asks for xml. a "format" parameter picks
+// what Get returns: "csv" (the default for observations), "json" (for
+// everything else), or "typed" for Observations, SeriesList, Categories
+// or VintageDates
 type FredEngine struct {
 	Key []byte
+	// FredBaseURL if empty
+	BaseURL string
 }
 
 func (e FredEngine) Get(r Reference) (*http.Response, error) {
-	// modify a copy of uri:
-	u, err := url.Parse(r.URI().String())
+	return e.GetContext(context.Background(), r)
+}
+
+func (e FredEngine) GetContext(ctx context.Context, r Reference) (*http.Response, error) {
+	u, b, err := e.request(r)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
 	if err != nil {
 		return nil, err
 	}
-	q := u.Query()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	b.ReadCloser = resp.Body
+	resp.Body = b
+	return resp, nil
+}
+
+// carries what Process needs to know about the request
+type fredBody struct {
+	io.ReadCloser
+	endpoint string
+	format   string
+	vintage  bool
+	xml      bool
+}
+
+// the api url for a reference
+func (e FredEngine) request(r Reference) (*url.URL, *fredBody, error) {
+	ru := r.URI()
+	q := ru.Query()
+	b := fredBody{format: q.Get("format")}
+	q.Del("format")
+	switch ft := q.Get("file_type"); ft {
+	case "", "json":
+	case "xml":
+		b.xml = true
+	default:
+		return nil, nil, fmt.Errorf("unsupported fred file_type %q", ft)
+	}
+	var u *url.URL
+	if ru.Host != "" {
+		// modify a copy of uri:
+		x := *ru
+		u = &x
+		b.endpoint = strings.Trim(strings.TrimPrefix(u.Path, "/fred/"), "/")
+	} else {
+		base := e.BaseURL
+		if base == "" {
+			base = FredBaseURL
+		}
+		var err error
+		if u, err = url.Parse(base); err != nil {
+			return nil, nil, err
+		}
+		notFound := fmt.Errorf("%w (no fred endpoint for %v)", NotFound, r)
+		s := strings.Split(strings.Trim(ru.Path, "/"), "/")
+		switch {
+		case len(s) == 1 && s[0] == "search":
+			b.endpoint = "series/search"
+		case len(s) < 2:
+			return nil, nil, notFound
+		case s[0] == "series":
+			q.Set("series_id", s[1])
+			switch strings.Join(s[2:], "/") {
+			case "":
+				b.endpoint = "series"
+			case "observations":
+				b.endpoint = "series/observations"
+			case "vintages":
+				b.endpoint = "series/vintagedates"
+			default:
+				return nil, nil, notFound
+			}
+		case s[0] == "category":
+			q.Set("category_id", s[1])
+			switch strings.Join(s[2:], "/") {
+			case "":
+				b.endpoint = "category"
+			case "children", "series":
+				b.endpoint = "category/" + s[2]
+			default:
+				return nil, nil, notFound
+			}
+		default:
+			return nil, nil, notFound
+		}
+		u = u.ResolveReference(&url.URL{Path: b.endpoint})
+	}
+	for _, k := range []string{"realtime_start", "realtime_end", "vintage_dates"} {
+		if q.Get(k) != "" {
+			b.vintage = true
+		}
+	}
 	q.Set("api_key", strings.TrimSpace(string(e.Key)))
+	if !b.xml {
+		q.Set("file_type", "json")
+	}
 	u.RawQuery = q.Encode()
-	return http.Get(u.String())
+	return u, &b, nil
 }
 
 type Observations struct {
-	Start        string         `xml:"observation_start,attr"`
-	End          string         `xml:"observation_end,attr"`
-	Observations []*Observation `xml:"observation"`
+	RealtimeStart string         `json:"realtime_start,omitempty" xml:"realtime_start,attr"`
+	RealtimeEnd   string         `json:"realtime_end,omitempty" xml:"realtime_end,attr"`
+	Start         string         `json:"observation_start,omitempty" xml:"observation_start,attr"`
+	End           string         `json:"observation_end,omitempty" xml:"observation_end,attr"`
+	Units         string         `json:"units,omitempty" xml:"units,attr"`
+	Count         int            `json:"count" xml:"count,attr"`
+	Observations  []*Observation `json:"observations" xml:"observation"`
 }
 
 type Observation struct {
-	Date  string  `xml:"date,attr"`
-	Value float64 `xml:"value,attr"`
+	RealtimeStart string `json:"realtime_start,omitempty"`
+	RealtimeEnd   string `json:"realtime_end,omitempty"`
+	Date          string `json:"date"`
+	// nil where there's no value, which fred writes as "."
+	Value *float64 `json:"value"`
+}
+
+func (o *Observation) UnmarshalJSON(buf []byte) error {
+	var x struct {
+		RealtimeStart string          `json:"realtime_start"`
+		RealtimeEnd   string          `json:"realtime_end"`
+		Date          string          `json:"date"`
+		Value         json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(buf, &x); err != nil {
+		return err
+	}
+	// fred quotes values, but our own json doesn't
+	return o.set(x.RealtimeStart, x.RealtimeEnd, x.Date, strings.Trim(string(x.Value), `"`))
+}
+
+func (o *Observation) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var x struct {
+		RealtimeStart string `xml:"realtime_start,attr"`
+		RealtimeEnd   string `xml:"realtime_end,attr"`
+		Date          string `xml:"date,attr"`
+		Value         string `xml:"value,attr"`
+	}
+	if err := d.DecodeElement(&x, &start); err != nil {
+		return err
+	}
+	return o.set(x.RealtimeStart, x.RealtimeEnd, x.Date, x.Value)
+}
+
+func (o *Observation) set(realtimeStart, realtimeEnd, date, value string) error {
+	*o = Observation{RealtimeStart: realtimeStart, RealtimeEnd: realtimeEnd, Date: date}
+	switch value {
+	case "", ".", "null":
+		return nil
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return fmt.Errorf("bad value for %s: %w", date, err)
+	}
+	o.Value = &v
+	return nil
 }
 
 func (o Observations) String() string {
@@ -44,26 +209,159 @@ func (o Observations) String() string {
 	return string(buf)
 }
 
+type Series struct {
+	ID                      string `json:"id" xml:"id,attr"`
+	RealtimeStart           string `json:"realtime_start,omitempty" xml:"realtime_start,attr"`
+	RealtimeEnd             string `json:"realtime_end,omitempty" xml:"realtime_end,attr"`
+	Title                   string `json:"title" xml:"title,attr"`
+	ObservationStart        string `json:"observation_start" xml:"observation_start,attr"`
+	ObservationEnd          string `json:"observation_end" xml:"observation_end,attr"`
+	Frequency               string `json:"frequency" xml:"frequency,attr"`
+	FrequencyShort          string `json:"frequency_short,omitempty" xml:"frequency_short,attr"`
+	Units                   string `json:"units" xml:"units,attr"`
+	UnitsShort              string `json:"units_short,omitempty" xml:"units_short,attr"`
+	SeasonalAdjustment      string `json:"seasonal_adjustment" xml:"seasonal_adjustment,attr"`
+	SeasonalAdjustmentShort string `json:"seasonal_adjustment_short,omitempty" xml:"seasonal_adjustment_short,attr"`
+	LastUpdated             string `json:"last_updated" xml:"last_updated,attr"`
+	Popularity              int    `json:"popularity" xml:"popularity,attr"`
+	Notes                   string `json:"notes,omitempty" xml:"notes,attr"`
+}
+
+type SeriesList []*Series
+
+type Category struct {
+	ID       int    `json:"id" xml:"id,attr"`
+	Name     string `json:"name" xml:"name,attr"`
+	ParentID int    `json:"parent_id" xml:"parent_id,attr"`
+}
+
+type Categories []*Category
+
+type VintageDates []string
+
+func (o Observations) rows(vintage bool) [][]string {
+	header := []string{"date", "value"}
+	if vintage {
+		header = append(header, "realtime_start", "realtime_end")
+	}
+	out := [][]string{header}
+	for _, x := range o.Observations {
+		var v string
+		if x.Value != nil {
+			v = fmt.Sprintf("%f", *x.Value)
+		}
+		row := []string{x.Date, v}
+		if vintage {
+			row = append(row, x.RealtimeStart, x.RealtimeEnd)
+		}
+		out = append(out, row)
+	}
+	return out
+}
+
+func (l SeriesList) rows(bool) [][]string {
+	out := [][]string{{"id", "title", "frequency", "units", "seasonal_adjustment", "observation_start", "observation_end", "last_updated", "popularity"}}
+	for _, s := range l {
+		out = append(out, []string{s.ID, s.Title, s.Frequency, s.Units, s.SeasonalAdjustment, s.ObservationStart, s.ObservationEnd, s.LastUpdated, strconv.Itoa(s.Popularity)})
+	}
+	return out
+}
+
+func (l Categories) rows(bool) [][]string {
+	out := [][]string{{"id", "name", "parent_id"}}
+	for _, c := range l {
+		out = append(out, []string{strconv.Itoa(c.ID), c.Name, strconv.Itoa(c.ParentID)})
+	}
+	return out
+}
+
+func (l VintageDates) rows(bool) [][]string {
+	out := [][]string{{"vintage_date"}}
+	for _, d := range l {
+		out = append(out, []string{d})
+	}
+	return out
+}
+
+type fredTable interface {
+	rows(vintage bool) [][]string
+}
+
 func (e FredEngine) Process(rc io.ReadCloser) (interface{}, error) {
 	defer rc.Close()
-	d := xml.NewDecoder(rc)
-	var f Observations
-	if err := d.Decode(&f); err != nil {
-		return nil, err
+	b, ok := rc.(*fredBody)
+	if !ok {
+		b = &fredBody{endpoint: "series/observations"}
 	}
-	w := new(bytes.Buffer)
-	w2 := csv.NewWriter(w)
-	if err := w2.Write([]string{"date", "value"}); err != nil {
-		return nil, err
+	var decode func(interface{}) error
+	if b.xml {
+		decode = xml.NewDecoder(rc).Decode
+	} else {
+		decode = json.NewDecoder(rc).Decode
 	}
-	for _, o := range f.Observations {
-		if err := w2.Write([]string{o.Date, fmt.Sprintf("%f", o.Value)}); err != nil {
+	var t fredTable
+	switch b.endpoint {
+	case "series/observations":
+		var o Observations
+		if err := decode(&o); err != nil {
+			return nil, err
+		}
+		t = o
+	case "series", "series/search", "category/series":
+		var x struct {
+			Seriess SeriesList `json:"seriess" xml:"series"`
+		}
+		if err := decode(&x); err != nil {
 			return nil, err
 		}
+		t = x.Seriess
+	case "category", "category/children":
+		var x struct {
+			Categories Categories `json:"categories" xml:"category"`
+		}
+		if err := decode(&x); err != nil {
+			return nil, err
+		}
+		t = x.Categories
+	case "series/vintagedates":
+		var x struct {
+			VintageDates VintageDates `json:"vintage_dates" xml:"vintage_date"`
+		}
+		if err := decode(&x); err != nil {
+			return nil, err
+		}
+		t = x.VintageDates
+	default:
+		// some other endpoint, which we just pass on
+		if b.format != "" && b.format != "json" {
+			return nil, fmt.Errorf("no format %q for fred endpoint %q", b.format, b.endpoint)
+		}
+		w := new(bytes.Buffer)
+		if _, err := io.Copy(w, rc); err != nil {
+			return nil, err
+		}
+		return w.Bytes(), nil
 	}
-	w2.Flush()
-	if err := w2.Error(); err != nil {
-		return nil, err
+	format := b.format
+	if format == "" {
+		format = "json"
+		if b.endpoint == "series/observations" {
+			format = "csv"
+		}
+	}
+	switch format {
+	case "typed":
+		return t, nil
+	case "json":
+		return json.Marshal(t)
+	case "csv":
+		w := new(bytes.Buffer)
+		w2 := csv.NewWriter(w)
+		if err := w2.WriteAll(t.rows(b.vintage)); err != nil {
+			return nil, err
+		}
+		return w.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
 	}
-	return w.Bytes(), nil
 }
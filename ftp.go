@@ -2,43 +2,298 @@ package sc
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net"
 	"os"
+	"path"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
 )
 
+// FTPCombinator reads and writes files over sftp, keeping connections
+// alive for reuse between operations until it's closed; for plain ftp
+// or ftps, see FTP
 type FTPCombinator struct {
-	host, user, password string
+	addr        string
+	config      *ssh.ClientConfig
+	agentSocket string // if using an agent
+	pool        *sftpPool
 }
 
+type SFTPOptions struct {
+	// host, or host:port
+	Host string
+	// used if Host has no port, or else 22
+	Port int
+	User string
+	// authentication methods, tried as the server allows: a password,
+	// a private key in pem form, or a file containing one, and the
+	// keys of an ssh agent
+	Password      string
+	Key           []byte
+	KeyFile       string
+	KeyPassphrase string
+	Agent         bool
+	// the agent's socket, $SSH_AUTH_SOCK if empty
+	AgentSocket string
+	// verifies the server's host key; if nil, host keys are checked
+	// against KnownHosts, or ~/.ssh/known_hosts if that's empty
+	HostKeyCallback ssh.HostKeyCallback
+	KnownHosts      []string
+	// skips host key verification, which is insecure
+	InsecureIgnoreHostKey bool
+	// idle connections kept for reuse, DefaultSFTPMaxIdle if zero,
+	// or none if negative
+	MaxIdle int
+	// how long they're kept, DefaultSFTPIdleTimeout if zero
+	IdleTimeout time.Duration
+}
+
+// NewFTPCombinator logs in with a password, verifying the host against ~/.ssh/known_hosts
 func NewFTPCombinator(host, user, password string) (*FTPCombinator, error) {
-	ftp := &FTPCombinator{
-		host:     host,
-		user:     user,
-		password: password,
-	}
-	return ftp, nil
+	return NewSFTP(SFTPOptions{Host: host, User: user, Password: password})
 }
 
-func (f FTPCombinator) login() (*sftp.Client, error) {
+func NewSFTP(opts SFTPOptions) (*FTPCombinator, error) {
+	if opts.Host == "" {
+		return nil, fmt.Errorf("needs host")
+	}
+	addr := opts.Host
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		port := opts.Port
+		if port == 0 {
+			port = 22
+		}
+		addr = net.JoinHostPort(opts.Host, strconv.Itoa(port))
+	}
 	config := &ssh.ClientConfig{
-		User: f.user,
-		Auth: []ssh.AuthMethod{
-			ssh.Password(f.password),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		User:            opts.User,
+		HostKeyCallback: opts.HostKeyCallback,
+	}
+	if opts.Password != "" {
+		config.Auth = append(config.Auth, ssh.Password(opts.Password))
+	}
+	key := opts.Key
+	if opts.KeyFile != "" {
+		buf, err := ioutil.ReadFile(opts.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		key = buf
+	}
+	if len(key) > 0 {
+		var signer ssh.Signer
+		var err error
+		if opts.KeyPassphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(opts.KeyPassphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey(key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("bad private key: %w", err)
+		}
+		config.Auth = append(config.Auth, ssh.PublicKeys(signer))
+	}
+	var agentSocket string
+	if opts.Agent {
+		if agentSocket = opts.AgentSocket; agentSocket == "" {
+			agentSocket = os.Getenv("SSH_AUTH_SOCK")
+		}
+		if agentSocket == "" {
+			return nil, fmt.Errorf("no ssh agent socket")
+		}
+	}
+	if len(config.Auth) == 0 && agentSocket == "" {
+		return nil, fmt.Errorf("needs a password, key or agent")
+	}
+	switch {
+	case config.HostKeyCallback != nil:
+	case opts.InsecureIgnoreHostKey:
+		config.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+	default:
+		files := opts.KnownHosts
+		if len(files) == 0 {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return nil, err
+			}
+			file := filepath.Join(home, ".ssh", "known_hosts")
+			if _, err := os.Stat(file); os.IsNotExist(err) {
+				// fail when connecting, so what can't be verified can still be configured
+				config.HostKeyCallback = func(string, net.Addr, ssh.PublicKey) error {
+					return fmt.Errorf("can't verify host key without %s", file)
+				}
+				break
+			}
+			files = []string{file}
+		}
+		cb, err := knownhosts.New(files...)
+		if err != nil {
+			return nil, fmt.Errorf("can't load known hosts: %w", err)
+		}
+		config.HostKeyCallback = cb
+	}
+	return &FTPCombinator{
+		addr:        addr,
+		config:      config,
+		agentSocket: agentSocket,
+		pool:        newSFTPPool(opts.MaxIdle, opts.IdleTimeout),
+	}, nil
+}
+
+// sftp client, from the pool, which goes back when closed
+type sftpSession struct {
+	*sftp.Client
+	conn    *sftpConn
+	reused  bool // from the pool, rather than newly dialed
+	pool    *sftpPool
+	done    chan struct{}
+	stopped chan struct{}
+	once    *sync.Once
+}
+
+func (s sftpSession) Close() error {
+	s.once.Do(func() {
+		close(s.done)
+		<-s.stopped
+		s.pool.put(s.conn)
+	})
+	return nil
+}
+
+// a session on a pooled connection, or a new one
+func (f FTPCombinator) login(ctx context.Context) (*sftpSession, error) {
+	if f.config == nil {
+		return nil, fmt.Errorf("sftp combinator not configured")
+	}
+	c, reused, err := f.pool.get(ctx, f.dial)
+	if err != nil {
+		return nil, err
+	}
+	return f.session(ctx, c, reused), nil
+}
+
+func (f FTPCombinator) session(ctx context.Context, c *sftpConn, reused bool) *sftpSession {
+	deadline, _ := ctx.Deadline()
+	c.tcp.SetDeadline(deadline)
+	s := &sftpSession{
+		Client:  c.client,
+		conn:    c,
+		reused:  reused,
+		pool:    f.pool,
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+		once:    new(sync.Once),
+	}
+	// tear down the connection if the context ends mid-operation
+	go func() {
+		defer close(s.stopped)
+		select {
+		case <-ctx.Done():
+			c.close()
+		case <-s.done:
+		}
+	}()
+	return s
+}
+
+// whether err came from the connection rather than the server
+func sftpTransportError(ctx context.Context, err error) bool {
+	var se *sftp.StatusError
+	return err != nil && ctx.Err() == nil && !errors.As(err, &se) &&
+		!errors.Is(err, os.ErrNotExist) && !errors.Is(err, os.ErrPermission) && !errors.Is(err, NotFound)
+}
+
+// a session in which op has made the first requests; a pooled connection
+// can die unnoticed between uses, so if op fails on one for want of a
+// transport, it's tried once more on a new connection. op should hold off
+// consuming anything it can't repeat
+func (f FTPCombinator) open(ctx context.Context, op func(*sftpSession) error) (*sftpSession, error) {
+	s, err := f.login(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = op(s)
+	if s.reused && sftpTransportError(ctx, err) {
+		s.conn.close()
+		s.Close()
+		c, derr := f.dial(ctx)
+		if derr != nil {
+			return nil, derr
+		}
+		s = f.session(ctx, c, false)
+		err = op(s)
+	}
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// runs op in a session, as open does
+func (f FTPCombinator) with(ctx context.Context, op func(*sftpSession) error) error {
+	s, err := f.open(ctx, op)
+	if err != nil {
+		return err
+	}
+	return s.Close()
+}
+
+func (f FTPCombinator) dial(ctx context.Context) (*sftpConn, error) {
+	config := *f.config
+	if f.agentSocket != "" {
+		// the agent signs over this connection during the handshake
+		conn, err := net.Dial("unix", f.agentSocket)
+		if err != nil {
+			return nil, fmt.Errorf("can't reach ssh agent: %w", err)
+		}
+		defer conn.Close()
+		config.Auth = append(append([]ssh.AuthMethod(nil), config.Auth...), ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+	}
+	var d net.Dialer
+	tcp, err := d.DialContext(ctx, "tcp", f.addr)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		tcp.SetDeadline(deadline)
 	}
-	conn, err := ssh.Dial("tcp", fmt.Sprintf("%s:22", f.host), config)
+	c, chans, reqs, err := ssh.NewClientConn(tcp, f.addr, &config)
 	if err != nil {
+		tcp.Close()
 		return nil, err
 	}
-	return sftp.NewClient(conn)
+	sc := ssh.NewClient(c, chans, reqs)
+	client, err := sftp.NewClient(sc)
+	if err != nil {
+		sc.Close()
+		return nil, err
+	}
+	return newSFTPConn(tcp, sc, client), nil
+}
+
+// Close closes idle connections, and those in use once they're done with
+func (f FTPCombinator) Close() error {
+	if f.pool == nil {
+		return nil
+	}
+	f.pool.close()
+	return nil
 }
 
 type Listing struct {
@@ -59,12 +314,24 @@ func NewListing(fi os.FileInfo) Listing {
 	}
 }
 
-func (f FTPCombinator) Get(r Reference) (interface{}, error) {
-	s, err := f.login()
-	if err != nil {
-		return nil, err
+// directory contents, as json lines
+func encodeListings(list []Listing) ([]byte, error) {
+	w := new(bytes.Buffer)
+	e := json.NewEncoder(w)
+	e.SetEscapeHTML(false)
+	for _, x := range list {
+		if err := e.Encode(x); err != nil {
+			return nil, err
+		}
 	}
-	defer s.Close()
+	return w.Bytes(), nil
+}
+
+func (f FTPCombinator) Get(r Reference) (interface{}, error) {
+	return f.GetContext(context.Background(), r)
+}
+
+func (f FTPCombinator) GetContext(ctx context.Context, r Reference) (interface{}, error) {
 	u := r.URI()
 	p := u.Path
 	for {
@@ -73,52 +340,247 @@ func (f FTPCombinator) Get(r Reference) (interface{}, error) {
 		}
 		p = p[1:]
 	}
-	list := func(dir string) (interface{}, error) {
+	var out interface{}
+	err := f.with(ctx, func(s *sftpSession) error {
+		list := func(dir string) error {
+			fis, err := s.ReadDir(dir)
+			if err != nil {
+				return err
+			}
+			var list []Listing
+			for _, fi := range fis {
+				list = append(list, NewListing(fi))
+			}
+			out, err = encodeListings(list)
+			return err
+		}
+		if len(p) == 0 {
+			return list(".")
+		}
+		file, err := s.Open(p)
+		if err != nil {
+			return wrapNotFound(r, err)
+		}
+		defer file.Close()
+		fi, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return list(p)
+		}
 		w := new(bytes.Buffer)
-		e := json.NewEncoder(w)
-		e.SetEscapeHTML(false)
-		list, err := s.ReadDir(p)
+		n, err := io.Copy(w, contextReader{ctx: ctx, r: file})
 		if err != nil {
-			return nil, err
+			return err
 		}
-		for _, fi := range list {
-			if err := e.Encode(NewListing(fi)); err != nil {
-				return nil, err
-			}
+		if n != fi.Size() {
+			return fmt.Errorf("expected %d bytes, got %d", fi.Size(), n)
 		}
-		return w.Bytes(), nil
+		out = w.Bytes()
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	if len(p) == 0 {
-		return list(".")
+	return out, nil
+}
+
+func (f FTPCombinator) Put(r Reference, i interface{}) error {
+	return f.PutContext(context.Background(), r, i)
+}
+
+// puts are atomic, via a temporary file which is renamed into place
+func (f FTPCombinator) PutContext(ctx context.Context, r Reference, i interface{}) error {
+	p := removeLeadingSlashes(r.URI().Path)
+	if p == "" {
+		return fmt.Errorf("can't put to root")
 	}
-	file, err := s.Open(p)
+	rc, err := Reader(i)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	fi, err := file.Stat()
+	defer rc.Close()
+	tmp := path.Join(path.Dir(p), "."+path.Base(p)+".tmp"+uuid.New().String())
+	var file *sftp.File
+	s, err := f.open(ctx, func(s *sftpSession) (err error) {
+		if dir := path.Dir(p); dir != "." {
+			if err := s.MkdirAll(dir); err != nil {
+				return err
+			}
+		}
+		file, err = s.Create(tmp)
+		return err
+	})
 	if err != nil {
-		return nil, err
+		return err
 	}
-	if fi.IsDir() {
-		return list(p)
+	defer s.Close()
+	defer s.Remove(tmp)
+	if _, err := io.Copy(file, contextReader{ctx: ctx, r: rc}); err != nil {
+		file.Close()
+		return err
 	}
-	w := new(bytes.Buffer)
-	n, err := io.Copy(w, file)
+	if err := file.Close(); err != nil {
+		return err
+	}
+	err = s.PosixRename(tmp, p)
+	var se *sftp.StatusError
+	if !errors.As(err, &se) || se.FxCode() != sftp.ErrSSHFxOpUnsupported {
+		return err
+	}
+	// without the posix extension, renames can't replace files
+	if err := s.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return s.Rename(tmp, p)
+}
+
+func (f FTPCombinator) Delete(r Reference) error {
+	return f.DeleteContext(context.Background(), r)
+}
+
+func (f FTPCombinator) DeleteContext(ctx context.Context, r Reference) error {
+	p := removeLeadingSlashes(r.URI().Path)
+	return f.with(ctx, func(s *sftpSession) error {
+		if _, err := s.Stat(p); err != nil {
+			return wrapNotFound(r, err)
+		}
+		return s.Remove(p)
+	})
+}
+
+func (f FTPCombinator) Merge(r Reference, i interface{}) error {
+	return f.MergeContext(context.Background(), r, i)
+}
+
+// merges append
+func (f FTPCombinator) MergeContext(ctx context.Context, r Reference, i interface{}) error {
+	rc, err := Reader(i)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	if n != fi.Size() {
-		return nil, fmt.Errorf("expected %d bytes, got %d", fi.Size(), n)
+	defer rc.Close()
+	p := removeLeadingSlashes(r.URI().Path)
+	var file *sftp.File
+	s, err := f.open(ctx, func(s *sftpSession) (err error) {
+		if dir := path.Dir(p); dir != "." {
+			if err := s.MkdirAll(dir); err != nil {
+				return err
+			}
+		}
+		if file, err = s.OpenFile(p, os.O_WRONLY|os.O_CREATE); err != nil {
+			return err
+		}
+		// not every server honors O_APPEND, so write at the end explicitly
+		if _, err := file.Seek(0, io.SeekEnd); err != nil {
+			file.Close()
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
-	return w.Bytes(), nil
+	defer s.Close()
+	if _, err := io.Copy(file, contextReader{ctx: ctx, r: rc}); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
 }
 
-func (f FTPCombinator) Put(Reference, interface{}) error {
-	return unimplemented(f, "Put")
+func (f FTPCombinator) List(ctx context.Context, prefix Reference, opts ListOptions) (*ListPage, error) {
+	dir := listPath(prefix.URI().Path)
+	root := removeLeadingSlashes(dir)
+	if root == "" {
+		root = "."
+	}
+	var entries []Entry
+	add := func(p string, fi os.FileInfo) {
+		entries = append(entries, Entry{
+			Path:    listPath(p),
+			Size:    fi.Size(),
+			ModTime: fi.ModTime(),
+			IsDir:   fi.IsDir(),
+		})
+	}
+	err := f.with(ctx, func(s *sftpSession) error {
+		entries = nil
+		if opts.Recursive {
+			w := s.Walk(root)
+			for w.Step() {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				if err := w.Err(); err != nil {
+					return wrapNotFound(prefix, err)
+				}
+				add(w.Path(), w.Stat())
+			}
+			return nil
+		}
+		list, err := s.ReadDir(root)
+		if err != nil {
+			return wrapNotFound(prefix, err)
+		}
+		for _, fi := range list {
+			add(path.Join(dir, fi.Name()), fi)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return paginate(dir, entries, opts), nil
 }
-func (f FTPCombinator) Delete(Reference) error {
-	return unimplemented(f, "Delete")
+
+func (f FTPCombinator) Stat(ctx context.Context, r Reference) (*Metadata, error) {
+	p := removeLeadingSlashes(r.URI().Path)
+	if p == "" {
+		p = "."
+	}
+	var fi os.FileInfo
+	err := f.with(ctx, func(s *sftpSession) (err error) {
+		fi, err = s.Stat(p)
+		return err
+	})
+	if err != nil {
+		return nil, wrapNotFound(r, err)
+	}
+	m := Metadata{
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+		IsDir:   fi.IsDir(),
+	}
+	if !fi.IsDir() {
+		m.ContentType = contentType(p)
+	}
+	return &m, nil
 }
-func (f FTPCombinator) Merge(Reference, interface{}) error {
-	return unimplemented(f, "Merge")
+
+// streams a file, holding the connection open until closed
+func (f FTPCombinator) GetStream(ctx context.Context, r Reference) (io.ReadCloser, error) {
+	var (
+		file *sftp.File
+		fi   os.FileInfo
+	)
+	s, err := f.open(ctx, func(s *sftpSession) (err error) {
+		if file, err = s.Open(removeLeadingSlashes(r.URI().Path)); err != nil {
+			return wrapNotFound(r, err)
+		}
+		if fi, err = file.Stat(); err != nil {
+			file.Close()
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		file.Close()
+		s.Close()
+		return nil, fmt.Errorf("can't stream directory %v", r)
+	}
+	return multiCloser{Reader: contextReader{ctx: ctx, r: file}, closers: []io.Closer{file, s}}, nil
 }
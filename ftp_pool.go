@@ -0,0 +1,174 @@
+package sc
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	DefaultSFTPMaxIdle     = 4
+	DefaultSFTPIdleTimeout = 5 * time.Minute
+	// connections idle longer than this are checked before reuse
+	sftpHealthCheckAfter = 10 * time.Second
+	sftpHealthCheckWait  = 5 * time.Second
+)
+
+type sftpConn struct {
+	tcp    net.Conn
+	ssh    *ssh.Client
+	client *sftp.Client
+	dead   chan struct{} // closed once the connection ends
+	closed int32
+	used   time.Time
+}
+
+func newSFTPConn(tcp net.Conn, sc *ssh.Client, client *sftp.Client) *sftpConn {
+	c := &sftpConn{
+		tcp:    tcp,
+		ssh:    sc,
+		client: client,
+		dead:   make(chan struct{}),
+		used:   time.Now(),
+	}
+	go func() {
+		sc.Wait()
+		close(c.dead)
+	}()
+	return c
+}
+
+func (c *sftpConn) close() {
+	if atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		// the sftp client ends with the ssh connection; closing it too
+		// would race with the connection's own teardown
+		c.ssh.Close()
+	}
+}
+
+func (c *sftpConn) alive() bool {
+	if atomic.LoadInt32(&c.closed) != 0 {
+		return false
+	}
+	select {
+	case <-c.dead:
+		return false
+	default:
+		return true
+	}
+}
+
+// a round trip, to see whether the connection still works
+func (c *sftpConn) ping(ctx context.Context) error {
+	deadline := time.Now().Add(sftpHealthCheckWait)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	c.tcp.SetDeadline(deadline)
+	defer c.tcp.SetDeadline(time.Time{})
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := c.ssh.SendRequest("keepalive@openssh.com", true, nil)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		c.close()
+		return ctx.Err()
+	}
+}
+
+type sftpPool struct {
+	sync.Mutex
+	idle        []*sftpConn // most recently used last
+	maxIdle     int
+	idleTimeout time.Duration
+	closed      bool
+}
+
+func newSFTPPool(maxIdle int, idleTimeout time.Duration) *sftpPool {
+	switch {
+	case maxIdle == 0:
+		maxIdle = DefaultSFTPMaxIdle
+	case maxIdle < 0:
+		maxIdle = 0
+	}
+	if idleTimeout == 0 {
+		idleTimeout = DefaultSFTPIdleTimeout
+	}
+	return &sftpPool{maxIdle: maxIdle, idleTimeout: idleTimeout}
+}
+
+// a healthy idle connection, or else a new one; reused connections
+// which died since they were checked can still fail their next request
+func (p *sftpPool) get(ctx context.Context, dial func(context.Context) (*sftpConn, error)) (c *sftpConn, reused bool, err error) {
+	p.Lock()
+	for {
+		if p.closed {
+			p.Unlock()
+			return nil, false, fmt.Errorf("sftp combinator closed")
+		}
+		n := len(p.idle)
+		if n == 0 {
+			break
+		}
+		c := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		idle := time.Since(c.used)
+		if !c.alive() || idle > p.idleTimeout {
+			c.close()
+			continue
+		}
+		if idle < sftpHealthCheckAfter {
+			p.Unlock()
+			return c, true, nil
+		}
+		p.Unlock()
+		if err := c.ping(ctx); err == nil {
+			return c, true, nil
+		}
+		c.close()
+		if err := ctx.Err(); err != nil {
+			return nil, false, err
+		}
+		p.Lock()
+	}
+	p.Unlock()
+	c, err = dial(ctx)
+	return c, false, err
+}
+
+// returns a connection for reuse, unless it's broken or not needed
+func (p *sftpPool) put(c *sftpConn) {
+	if !c.alive() {
+		c.close()
+		return
+	}
+	c.tcp.SetDeadline(time.Time{})
+	c.used = time.Now()
+	p.Lock()
+	defer p.Unlock()
+	if p.closed || len(p.idle) >= p.maxIdle {
+		c.close()
+		return
+	}
+	p.idle = append(p.idle, c)
+}
+
+func (p *sftpPool) close() {
+	p.Lock()
+	defer p.Unlock()
+	p.closed = true
+	for _, c := range p.idle {
+		c.close()
+	}
+	p.idle = nil
+}
@@ -0,0 +1,519 @@
+package sc
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jlaffaye/ftp"
+)
+
+const (
+	DefaultFTPMaxIdle = 2
+	// idle connections used longer ago than this get a NOOP before reuse;
+	// servers commonly drop idle control connections after a few minutes
+	ftpHealthCheckAfter = 15 * time.Second
+)
+
+type FTPSecurity string
+
+const (
+	FTPPlain FTPSecurity = ""
+	// AUTH TLS after connecting
+	FTPExplicitTLS FTPSecurity = "explicit"
+	// tls from the start, usually on port 990
+	FTPImplicitTLS FTPSecurity = "implicit"
+)
+
+type FTPOptions struct {
+	// host, or host:port
+	Host string
+	// used if Host has no port, or else 21, or 990 for implicit tls
+	Port int
+	// anonymous if empty
+	User     string
+	Password string
+	Security FTPSecurity
+	// verifies the server's certificate against Host if nil
+	TLSConfig *tls.Config
+	// for connecting, ftp.DefaultDialTimeout if zero
+	Timeout time.Duration
+	// for servers which mishandle extended passive mode
+	DisableEPSV bool
+	// idle connections kept for reuse, DefaultFTPMaxIdle if zero,
+	// or none if negative
+	MaxIdle int
+}
+
+// FTP reads and writes files over ftp or ftps, always in passive mode,
+// with references resolved like those of FTPCombinator: relative to
+// the login directory, with directories read as json lines of Listings
+type FTP struct {
+	addr string
+	opts FTPOptions
+	pool *ftpPool
+}
+
+func NewFTP(opts FTPOptions) (*FTP, error) {
+	if opts.Host == "" {
+		return nil, fmt.Errorf("needs host")
+	}
+	switch opts.Security {
+	case FTPPlain, FTPExplicitTLS, FTPImplicitTLS:
+	default:
+		return nil, fmt.Errorf("unknown ftp security %q", opts.Security)
+	}
+	addr := opts.Host
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+		port := opts.Port
+		switch {
+		case port != 0:
+		case opts.Security == FTPImplicitTLS:
+			port = 990
+		default:
+			port = 21
+		}
+		addr = net.JoinHostPort(opts.Host, strconv.Itoa(port))
+	}
+	if opts.Security != FTPPlain && opts.TLSConfig == nil {
+		opts.TLSConfig = &tls.Config{ServerName: host}
+	}
+	if opts.User == "" {
+		opts.User, opts.Password = "anonymous", "anonymous"
+	}
+	max := opts.MaxIdle
+	switch {
+	case max == 0:
+		max = DefaultFTPMaxIdle
+	case max < 0:
+		max = 0
+	}
+	return &FTP{addr: addr, opts: opts, pool: &ftpPool{max: max}}, nil
+}
+
+type ftpPool struct {
+	sync.Mutex
+	idle   []*ftpConn
+	max    int
+	closed bool
+}
+
+type ftpConn struct {
+	*ftp.ServerConn
+	used time.Time
+}
+
+// an idle connection that still works, or else a new one
+func (f FTP) login(ctx context.Context) (*ftpConn, error) {
+	if f.pool == nil {
+		return nil, fmt.Errorf("ftp combinator not configured")
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	p := f.pool
+	p.Lock()
+	for !p.closed && len(p.idle) > 0 {
+		c := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		p.Unlock()
+		if time.Since(c.used) < ftpHealthCheckAfter || c.NoOp() == nil {
+			return c, nil
+		}
+		c.Quit()
+		p.Lock()
+	}
+	closed := p.closed
+	p.Unlock()
+	if closed {
+		return nil, fmt.Errorf("ftp combinator closed")
+	}
+	options := []ftp.DialOption{
+		ftp.DialWithContext(ctx),
+		ftp.DialWithDisabledEPSV(f.opts.DisableEPSV),
+	}
+	if f.opts.Timeout > 0 {
+		options = append(options, ftp.DialWithTimeout(f.opts.Timeout))
+	}
+	switch f.opts.Security {
+	case FTPExplicitTLS:
+		options = append(options, ftp.DialWithExplicitTLS(f.opts.TLSConfig))
+	case FTPImplicitTLS:
+		options = append(options, ftp.DialWithTLS(f.opts.TLSConfig))
+	}
+	c, err := ftp.Dial(f.addr, options...)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Login(f.opts.User, f.opts.Password); err != nil {
+		c.Quit()
+		return nil, err
+	}
+	return &ftpConn{ServerConn: c}, nil
+}
+
+// returns a connection to the pool, unless the error suggests it's broken
+func (f FTP) release(c *ftpConn, err error) {
+	var te *textproto.Error
+	if err != nil && !errors.As(err, &te) {
+		c.Quit()
+		return
+	}
+	c.used = time.Now()
+	p := f.pool
+	p.Lock()
+	defer p.Unlock()
+	if p.closed || len(p.idle) >= p.max {
+		c.Quit()
+		return
+	}
+	p.idle = append(p.idle, c)
+}
+
+// runs an operation on a pooled connection
+func (f FTP) with(ctx context.Context, op func(*ftpConn) error) error {
+	c, err := f.login(ctx)
+	if err != nil {
+		return err
+	}
+	err = op(c)
+	f.release(c, err)
+	return err
+}
+
+// Close logs out of idle connections, and those in use once they're done with
+func (f FTP) Close() error {
+	if f.pool == nil {
+		return nil
+	}
+	f.pool.Lock()
+	defer f.pool.Unlock()
+	f.pool.closed = true
+	for _, c := range f.pool.idle {
+		c.Quit()
+	}
+	f.pool.idle = nil
+	return nil
+}
+
+// ftp says 550 for missing files
+func wrapFTPNotFound(r Reference, err error) error {
+	var te *textproto.Error
+	if errors.As(err, &te) && te.Code == ftp.StatusFileUnavailable {
+		return fmt.Errorf("%w (%v: %s)", NotFound, r, te.Msg)
+	}
+	return err
+}
+
+func ftpPath(r Reference) string {
+	p := removeLeadingSlashes(r.URI().Path)
+	if p == "" {
+		return "."
+	}
+	return p
+}
+
+func ftpListing(e *ftp.Entry) Listing {
+	var mode os.FileMode
+	if e.Type == ftp.EntryTypeFolder {
+		mode |= os.ModeDir
+	}
+	return Listing{
+		Name:    e.Name,
+		Size:    int64(e.Size),
+		ModTime: e.Time,
+		Mode:    mode.String(),
+		IsDir:   e.Type == ftp.EntryTypeFolder,
+	}
+}
+
+// describes p by listing its directory, which uses MLSD where
+// the server has it
+func (c *ftpConn) entry(p string) (*ftp.Entry, error) {
+	if p == "." {
+		return &ftp.Entry{Name: ".", Type: ftp.EntryTypeFolder}, nil
+	}
+	list, err := c.List(path.Dir(p))
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range list {
+		if e.Name == path.Base(p) {
+			return e, nil
+		}
+	}
+	return nil, &textproto.Error{Code: ftp.StatusFileUnavailable, Msg: "no such file"}
+}
+
+func (f FTP) Get(r Reference) (interface{}, error) {
+	return f.GetContext(context.Background(), r)
+}
+
+func (f FTP) GetContext(ctx context.Context, r Reference) (interface{}, error) {
+	p := ftpPath(r)
+	var out []byte
+	err := f.with(ctx, func(c *ftpConn) error {
+		e, err := c.entry(p)
+		if err != nil {
+			return err
+		}
+		if e.Type == ftp.EntryTypeFolder {
+			entries, err := c.List(p)
+			if err != nil {
+				return err
+			}
+			var list []Listing
+			for _, e := range entries {
+				if e.Name != "." && e.Name != ".." {
+					list = append(list, ftpListing(e))
+				}
+			}
+			out, err = encodeListings(list)
+			return err
+		}
+		resp, err := c.Retr(p)
+		if err != nil {
+			return err
+		}
+		out, err = ioutil.ReadAll(contextReader{ctx: ctx, r: resp})
+		if cerr := resp.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	})
+	if err != nil {
+		return nil, wrapFTPNotFound(r, err)
+	}
+	return out, nil
+}
+
+// streams a file, holding its connection until closed
+func (f FTP) GetStream(ctx context.Context, r Reference) (io.ReadCloser, error) {
+	c, err := f.login(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p := ftpPath(r)
+	e, err := c.entry(p)
+	if err != nil {
+		f.release(c, err)
+		return nil, wrapFTPNotFound(r, err)
+	}
+	if e.Type == ftp.EntryTypeFolder {
+		f.release(c, nil)
+		return nil, fmt.Errorf("can't stream directory %v", r)
+	}
+	resp, err := c.Retr(p)
+	if err != nil {
+		f.release(c, err)
+		return nil, wrapFTPNotFound(r, err)
+	}
+	return &ftpStream{Reader: contextReader{ctx: ctx, r: resp}, resp: resp, release: func(err error) { f.release(c, err) }}, nil
+}
+
+type ftpStream struct {
+	io.Reader
+	resp    *ftp.Response
+	release func(error)
+	once    sync.Once
+}
+
+func (s *ftpStream) Close() error {
+	var err error
+	s.once.Do(func() {
+		err = s.resp.Close()
+		s.release(err)
+	})
+	return err
+}
+
+func (f FTP) Put(r Reference, i interface{}) error {
+	return f.PutContext(context.Background(), r, i)
+}
+
+// puts are atomic, via a temporary file which is renamed into place
+func (f FTP) PutContext(ctx context.Context, r Reference, i interface{}) error {
+	p := ftpPath(r)
+	if p == "." {
+		return fmt.Errorf("can't put to root")
+	}
+	rc, err := Reader(i)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return f.with(ctx, func(c *ftpConn) error {
+		c.mkdirs(path.Dir(p))
+		tmp := path.Join(path.Dir(p), "."+path.Base(p)+".tmp"+uuid.New().String())
+		if err := c.Stor(tmp, contextReader{ctx: ctx, r: rc}); err != nil {
+			c.Delete(tmp)
+			return err
+		}
+		err := c.Rename(tmp, p)
+		if err == nil {
+			return nil
+		}
+		if !c.refusedOverwrite(p, err) {
+			c.Delete(tmp)
+			return err
+		}
+		// the server won't rename over an existing file, so make room
+		if err := c.Delete(p); err != nil {
+			c.Delete(tmp)
+			return err
+		}
+		if err := c.Rename(tmp, p); err != nil {
+			return fmt.Errorf("deleted %v but couldn't rename %s into its place: %w", r, tmp, err)
+		}
+		return nil
+	})
+}
+
+// whether a rename failed because the server won't overwrite an existing file
+func (c *ftpConn) refusedOverwrite(p string, err error) bool {
+	var te *textproto.Error
+	if !errors.As(err, &te) || (te.Code != ftp.StatusFileUnavailable && te.Code != ftp.StatusBadFileName) {
+		return false
+	}
+	e, err := c.entry(p)
+	return err == nil && e.Type != ftp.EntryTypeFolder
+}
+
+// makes a directory and its parents, ignoring errors since
+// servers differ in how they report existing directories
+func (c *ftpConn) mkdirs(dir string) {
+	if dir == "." || dir == "/" {
+		return
+	}
+	var p string
+	for _, s := range strings.Split(dir, "/") {
+		p = path.Join(p, s)
+		c.MakeDir(p)
+	}
+}
+
+func (f FTP) Delete(r Reference) error {
+	return f.DeleteContext(context.Background(), r)
+}
+
+// like sftp, deletes files and empty directories
+func (f FTP) DeleteContext(ctx context.Context, r Reference) error {
+	p := ftpPath(r)
+	var dir bool
+	err := f.with(ctx, func(c *ftpConn) error {
+		e, err := c.entry(p)
+		if err != nil {
+			return err
+		}
+		if dir = e.Type == ftp.EntryTypeFolder; dir {
+			return c.RemoveDir(p)
+		}
+		return c.Delete(p)
+	})
+	if dir {
+		// the server's refusal, say of a full directory, isn't a missing one
+		return err
+	}
+	return wrapFTPNotFound(r, err)
+}
+
+func (f FTP) Merge(r Reference, i interface{}) error {
+	return f.MergeContext(context.Background(), r, i)
+}
+
+// merges append
+func (f FTP) MergeContext(ctx context.Context, r Reference, i interface{}) error {
+	p := ftpPath(r)
+	rc, err := Reader(i)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return f.with(ctx, func(c *ftpConn) error {
+		c.mkdirs(path.Dir(p))
+		return c.Append(p, contextReader{ctx: ctx, r: rc})
+	})
+}
+
+func (f FTP) List(ctx context.Context, prefix Reference, opts ListOptions) (*ListPage, error) {
+	dir := listPath(prefix.URI().Path)
+	root := ftpPath(prefix)
+	var entries []Entry
+	add := func(p string, e *ftp.Entry) {
+		entries = append(entries, Entry{
+			Path:    listPath(p),
+			Size:    int64(e.Size),
+			ModTime: e.Time,
+			IsDir:   e.Type == ftp.EntryTypeFolder,
+		})
+	}
+	err := f.with(ctx, func(c *ftpConn) error {
+		if opts.Recursive {
+			w := c.Walk(root)
+			for w.Next() {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				if err := w.Err(); err != nil {
+					return err
+				}
+				if e := w.Stat(); e.Type != ftp.EntryTypeFolder {
+					add(path.Join(dir, relativePath(listPath(root), listPath(w.Path()))), e)
+				}
+			}
+			return w.Err()
+		}
+		list, err := c.List(root)
+		if err != nil {
+			return err
+		}
+		for _, e := range list {
+			if e.Name != "." && e.Name != ".." {
+				add(path.Join(dir, e.Name), e)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, wrapFTPNotFound(prefix, err)
+	}
+	return paginate(dir, entries, opts), nil
+}
+
+func (f FTP) Stat(ctx context.Context, r Reference) (*Metadata, error) {
+	p := ftpPath(r)
+	var m Metadata
+	err := f.with(ctx, func(c *ftpConn) error {
+		e, err := c.entry(p)
+		if err != nil {
+			return err
+		}
+		m = Metadata{
+			Size:    int64(e.Size),
+			ModTime: e.Time,
+			IsDir:   e.Type == ftp.EntryTypeFolder,
+		}
+		if !m.IsDir {
+			m.ContentType = contentType(p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, wrapFTPNotFound(r, err)
+	}
+	return &m, nil
+}
@@ -8,10 +8,13 @@ require (
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible // indirect
 	github.com/golang/glog v0.0.0-20160126235308-23def4e6c14b // indirect
 	github.com/google/uuid v1.1.1
+	github.com/jlaffaye/ftp v0.0.0-20210307004419-5d4190119067
 	github.com/pkg/browser v0.0.0-20180916011732-0a3d74bf9ce4 // indirect
 	github.com/pkg/sftp v1.11.0
 	github.com/shengdoushi/base58 v1.0.0
 	github.com/snowflakedb/gosnowflake v1.3.4
+	github.com/stretchr/testify v1.8.0 // indirect
 	golang.org/x/crypto v0.0.0-20200221231518-2aa609cf4a9d
 	golang.org/x/sys v0.0.0-20200301204400-5d559ad92b82 // indirect
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -2,10 +2,14 @@ package sc
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
+	"errors"
 	"fmt"
+	"io"
 	"net/url"
 
+	"github.com/google/uuid"
 	"golang.org/x/crypto/sha3"
 )
 
@@ -68,74 +72,189 @@ func ParseHashRef(r Reference) (*HashReference, error) {
 }
 
 func (hc HashedContent) Get(r Reference) (interface{}, error) {
-	h0, err := ParseHashRef(r)
+	return hc.GetContext(context.Background(), r)
+}
+
+func (hc HashedContent) GetContext(ctx context.Context, r Reference) (interface{}, error) {
+	rc, err := hc.GetStream(ctx, r)
 	if err != nil {
 		return nil, err
 	}
-	i, err := hc.c.Get(r)
+	return Blob(rc)
+}
+
+// hashes content as it streams, failing at the end if it disagrees
+func (hc HashedContent) GetStream(ctx context.Context, r Reference) (io.ReadCloser, error) {
+	h0, err := ParseHashRef(r)
 	if err != nil {
 		return nil, err
 	}
-	b, err := Blob(i)
+	h, err := newHasher(h0.algorithm)
 	if err != nil {
 		return nil, err
 	}
-	h1, err := Hash(r.URI().Scheme, b)
+	rc, err := GetStream(ctx, hc.c, r)
 	if err != nil {
 		return nil, err
 	}
-	if bytes.Compare(h0.value, h1.value) != 0 {
-		return nil, fmt.Errorf("hashes disagree")
+	return &verifyingReader{rc: rc, h: h, want: h0.value}, nil
+}
+
+type verifyingReader struct {
+	rc   io.ReadCloser
+	h    hasher
+	want []byte
+	err  error // result of verification, once at end
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	if v.err != nil {
+		return 0, v.err
 	}
-	return b, nil
+	n, err := v.rc.Read(p)
+	v.h.Write(p[:n])
+	if err == io.EOF {
+		if bytes.Compare(v.h.sum(), v.want) != 0 {
+			v.err = fmt.Errorf("hashes disagree")
+		} else {
+			v.err = io.EOF
+		}
+		return n, v.err
+	}
+	return n, err
+}
+
+func (v *verifyingReader) Close() error {
+	return v.rc.Close()
 }
 
 func (hc HashedContent) Put(r Reference, i interface{}) error {
+	return hc.PutContext(context.Background(), r, i)
+}
+
+// in-memory content is verified before it's stored; streams are
+// stored aside and verified, then copied into place if they agree
+func (hc HashedContent) PutContext(ctx context.Context, r Reference, i interface{}) error {
 	h0, err := ParseHashRef(r)
 	if err != nil {
 		return err
 	}
-	b, err := Blob(i)
+	h, err := newHasher(h0.algorithm)
 	if err != nil {
 		return err
 	}
-	h1, err := Hash(r.URI().Scheme, b)
+	verify := func() error {
+		if bytes.Compare(h0.value, h.sum()) != 0 {
+			return fmt.Errorf("hashes disagree")
+		}
+		return nil
+	}
+	if t, ok := i.(io.Reader); ok {
+		if c, ok := t.(io.Closer); ok {
+			defer c.Close()
+		}
+		if ok, err := Exists(ctx, hc.c, r); err != nil {
+			return err
+		} else if ok {
+			// content at a hash can't change, so the stream's only checked
+			if _, err := io.Copy(h, contextReader{ctx: ctx, r: t}); err != nil {
+				return err
+			}
+			return verify()
+		}
+		u := r.URI()
+		tmp := NewURI(&url.URL{Scheme: u.Scheme, Opaque: u.Opaque + ".tmp" + uuid.New().String()})
+		err := PutContext(ctx, hc.c, tmp, io.TeeReader(t, h))
+		if err == nil {
+			err = verify()
+		}
+		if err == nil {
+			err = hc.move(ctx, tmp, r)
+		}
+		if derr := DeleteContext(ctx, hc.c, tmp); derr != nil && !errors.Is(derr, NotFound) {
+			if err == nil {
+				return derr
+			}
+			return fmt.Errorf("%v, and couldn't remove %v: %w", err, tmp, derr)
+		}
+		return err
+	}
+	b, err := Blob(i)
 	if err != nil {
 		return err
 	}
-	if bytes.Compare(h0.value, h1.value) != 0 {
-		return fmt.Errorf("hashes disagree")
+	h.Write(b)
+	if err := verify(); err != nil {
+		return err
+	}
+	return PutContext(ctx, hc.c, r, b)
+}
+
+// copies verified content into place
+func (hc HashedContent) move(ctx context.Context, from, to Reference) error {
+	rc, err := GetStream(ctx, hc.c, from)
+	if err != nil {
+		return err
 	}
-	return hc.c.Put(r, b)
+	defer rc.Close()
+	return PutContext(ctx, hc.c, to, rc)
 }
 
 func (hc HashedContent) Delete(r Reference) error {
+	return hc.DeleteContext(context.Background(), r)
+}
+
+func (hc HashedContent) DeleteContext(context.Context, Reference) error {
 	return unimplemented(hc, "Delete")
 }
 
 func (hc HashedContent) Merge(r Reference, i interface{}) error {
+	return hc.MergeContext(context.Background(), r, i)
+}
+
+func (hc HashedContent) MergeContext(context.Context, Reference, interface{}) error {
 	return unimplemented(hc, "Merge")
 }
 
 func Hash(algo string, buf []byte) (*HashReference, error) {
-	var value []byte
+	h, err := newHasher(algo)
+	if err != nil {
+		return nil, err
+	}
+	h.Write(buf)
+	return &HashReference{algorithm: algo, value: h.sum()}, nil
+}
+
+// incremental form of Hash
+type hasher interface {
+	io.Writer
+	sum() []byte
+}
+
+func newHasher(algo string) (hasher, error) {
 	switch algo {
 	case Shake256:
 		h := sha3.NewShake256()
-		if _, err := h.Write(buf); err != nil {
-			return nil, err
-		}
-		out := make([]byte, 64)
-		if _, err := h.Read(out); err != nil {
-			return nil, err
-		}
-		value = out
+		return funcHasher{Writer: h, f: func() []byte {
+			out := make([]byte, 64)
+			h.Clone().Read(out)
+			return out
+		}}, nil
 	case MD5:
 		h := md5.New()
-		h.Write(buf)
-		value = h.Sum(nil)
+		return funcHasher{Writer: h, f: func() []byte {
+			return h.Sum(nil)
+		}}, nil
 	default:
 		return nil, fmt.Errorf("hash algo %q not supported", algo)
 	}
-	return &HashReference{algorithm: algo, value: value}, nil
+}
+
+type funcHasher struct {
+	io.Writer
+	f func() []byte
+}
+
+func (h funcHasher) sum() []byte {
+	return h.f()
 }
@@ -1,5 +1,7 @@
 package sc
 
+import "context"
+
 type KeyValue interface {
 	Put(key string, value interface{}) error
 	Get(key string) (interface{}, error)
@@ -14,17 +16,39 @@ func NewKeyValue(kv KeyValue) *KeyValueCombinator {
 }
 
 func (c KeyValueCombinator) Get(r Reference) (interface{}, error) {
+	return c.GetContext(context.Background(), r)
+}
+
+func (c KeyValueCombinator) GetContext(ctx context.Context, r Reference) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	return c.kv.Get(r.URI().String())
 }
 
 func (c KeyValueCombinator) Put(r Reference, i interface{}) error {
+	return c.PutContext(context.Background(), r, i)
+}
+
+func (c KeyValueCombinator) PutContext(ctx context.Context, r Reference, i interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	return c.kv.Put(r.URI().String(), i)
 }
 
-func (c KeyValueCombinator) Delete(Reference) error {
+func (c KeyValueCombinator) Delete(r Reference) error {
+	return c.DeleteContext(context.Background(), r)
+}
+
+func (c KeyValueCombinator) DeleteContext(context.Context, Reference) error {
 	return unimplemented(c, "Delete")
 }
 
-func (c KeyValueCombinator) Merge(Reference, interface{}) error {
+func (c KeyValueCombinator) Merge(r Reference, i interface{}) error {
+	return c.MergeContext(context.Background(), r, i)
+}
+
+func (c KeyValueCombinator) MergeContext(context.Context, Reference, interface{}) error {
 	return unimplemented(c, "Merge")
 }
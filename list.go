@@ -0,0 +1,146 @@
+package sc
+
+import (
+	"context"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Lister is implemented by combinators that can enumerate what they hold
+type Lister interface {
+	List(ctx context.Context, prefix Reference, opts ListOptions) (*ListPage, error)
+}
+
+type ListOptions struct {
+	// continuation token from a previous page, empty for the first page
+	Token string
+	// maximum number of entries per page, or zero for the combinator's default
+	Limit int
+	// list everything beneath the prefix, not just its immediate children;
+	// recursive listings only contain leaf (non-directory) entries
+	Recursive bool
+}
+
+type ListPage struct {
+	Entries []Entry
+	// token for the next page, empty if this is the last one
+	Next string
+}
+
+// Entry is one item of a listing, and is also a reference to that
+// item for the combinator which listed it
+type Entry struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+	IsDir   bool
+	base    *url.URL // scheme and host of the listed prefix, if any
+}
+
+func (e Entry) URI() *url.URL {
+	var u url.URL
+	if e.base != nil {
+		u.Scheme = e.base.Scheme
+		u.Host = e.base.Host
+	}
+	u.Path = e.Path
+	return &u
+}
+
+func (e Entry) String() string {
+	return e.URI().String()
+}
+
+// List lists one page of entries beneath prefix, if c supports it
+func List(ctx context.Context, c StorageCombinator, prefix Reference, opts ListOptions) (*ListPage, error) {
+	l, ok := c.(Lister)
+	if !ok {
+		return nil, unsupported(c, "List")
+	}
+	return l.List(ctx, prefix, opts)
+}
+
+// ListAll follows pagination to list every entry beneath prefix
+func ListAll(ctx context.Context, c StorageCombinator, prefix Reference, recursive bool) ([]Entry, error) {
+	var out []Entry
+	opts := ListOptions{Recursive: recursive}
+	for {
+		page, err := List(ctx, c, prefix, opts)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, page.Entries...)
+		if page.Next == "" {
+			return out, nil
+		}
+		opts.Token = page.Next
+	}
+}
+
+const DefaultListLimit = 1000
+
+// cleans a path to absolute form without trailing slash, "/" for root
+func listPath(p string) string {
+	return path.Clean("/" + p)
+}
+
+// whether p lies beneath the directory dir, both in listPath form
+func beneath(dir, p string) bool {
+	if dir == "/" {
+		return p != "/"
+	}
+	return strings.HasPrefix(p, dir+"/")
+}
+
+// reduces a set of entries beneath dir to a single page,
+// synthesizing directories for non-recursive listings;
+// the token is simply the last path of the previous page
+func paginate(dir string, entries []Entry, opts ListOptions) *ListPage {
+	dir = listPath(dir)
+	children := make(map[string]Entry)
+	for _, e := range entries {
+		p := listPath(e.Path)
+		if !beneath(dir, p) {
+			continue
+		}
+		if opts.Recursive {
+			if !e.IsDir {
+				children[e.Path] = e
+			}
+			continue
+		}
+		rest := strings.TrimPrefix(p, dir)
+		rest = strings.TrimPrefix(rest, "/")
+		if i := strings.Index(rest, "/"); i >= 0 {
+			child := path.Join(dir, rest[:i])
+			if _, ok := children[child]; !ok {
+				children[child] = Entry{Path: child, IsDir: true, base: e.base}
+			}
+			continue
+		}
+		children[e.Path] = e
+	}
+	var sorted []Entry
+	for _, e := range children {
+		if opts.Token != "" && e.Path <= opts.Token {
+			continue
+		}
+		sorted = append(sorted, e)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Path < sorted[j].Path
+	})
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+	page := &ListPage{Entries: sorted}
+	if len(sorted) > limit {
+		page.Entries = sorted[:limit]
+		page.Next = sorted[limit-1].Path
+	}
+	return page
+}
@@ -2,8 +2,10 @@ package sc
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"time"
 )
 
@@ -21,10 +23,11 @@ func NewListingCombinator(raw StorageCombinator, listReference Reference) *Listi
 }
 
 func (lc ListingCombinator) Get(r Reference) (interface{}, error) {
-	if r.URI().String() == lc.listReference.URI().String() {
-		return lc.raw.Get(r)
-	}
-	return lc.raw.Get(r)
+	return lc.GetContext(context.Background(), r)
+}
+
+func (lc ListingCombinator) GetContext(ctx context.Context, r Reference) (interface{}, error) {
+	return GetContext(ctx, lc.raw, r)
 }
 
 type ListRecord struct {
@@ -33,10 +36,17 @@ type ListRecord struct {
 	Mode string
 }
 
-func (lc ListingCombinator) update(r Reference, mode string) error {
+func (lc ListingCombinator) conflict(r Reference) error {
 	if list := lc.listReference.URI().String(); r.URI().String() == list {
 		return fmt.Errorf("path conflict with listing: %s", list)
 	}
+	return nil
+}
+
+func (lc ListingCombinator) update(ctx context.Context, r Reference, mode string) error {
+	if err := lc.conflict(r); err != nil {
+		return err
+	}
 	lr := ListRecord{
 		Time: time.Now(),
 		URI:  r.URI().String(),
@@ -48,26 +58,72 @@ func (lc ListingCombinator) update(r Reference, mode string) error {
 	if err := e.Encode(lr); err != nil {
 		return err
 	}
-	return lc.raw.Merge(lc.listReference, w.Bytes())
+	return MergeContext(ctx, lc.raw, lc.listReference, w.Bytes())
 }
 
 func (lc ListingCombinator) Put(r Reference, i interface{}) error {
-	if err := lc.update(r, "put"); err != nil {
+	return lc.PutContext(context.Background(), r, i)
+}
+
+func (lc ListingCombinator) PutContext(ctx context.Context, r Reference, i interface{}) error {
+	if err := lc.update(ctx, r, "put"); err != nil {
 		return err
 	}
-	return lc.raw.Put(r, i)
+	return PutContext(ctx, lc.raw, r, i)
 }
 
 func (lc ListingCombinator) Delete(r Reference) error {
-	if err := lc.update(r, "delete"); err != nil {
+	return lc.DeleteContext(context.Background(), r)
+}
+
+func (lc ListingCombinator) DeleteContext(ctx context.Context, r Reference) error {
+	if err := lc.update(ctx, r, "delete"); err != nil {
 		return err
 	}
-	return lc.raw.Delete(r)
+	return DeleteContext(ctx, lc.raw, r)
 }
 
 func (lc ListingCombinator) Merge(r Reference, i interface{}) error {
-	if err := lc.update(r, "merge"); err != nil {
+	return lc.MergeContext(context.Background(), r, i)
+}
+
+func (lc ListingCombinator) MergeContext(ctx context.Context, r Reference, i interface{}) error {
+	if err := lc.update(ctx, r, "merge"); err != nil {
 		return err
 	}
-	return lc.raw.Merge(r, i)
+	return MergeContext(ctx, lc.raw, r, i)
+}
+
+func (lc ListingCombinator) List(ctx context.Context, prefix Reference, opts ListOptions) (*ListPage, error) {
+	return List(ctx, lc.raw, prefix, opts)
+}
+
+func (lc ListingCombinator) Stat(ctx context.Context, r Reference) (*Metadata, error) {
+	return Stat(ctx, lc.raw, r)
+}
+
+// conditional writes are only recorded once they've succeeded,
+// since failed preconditions are an expected outcome
+func (lc ListingCombinator) PutIf(ctx context.Context, r Reference, i interface{}, cond Conditions) error {
+	if err := lc.conflict(r); err != nil {
+		return err
+	}
+	if err := PutIf(ctx, lc.raw, r, i, cond); err != nil {
+		return err
+	}
+	return lc.update(ctx, r, "put")
+}
+
+func (lc ListingCombinator) DeleteIf(ctx context.Context, r Reference, cond Conditions) error {
+	if err := lc.conflict(r); err != nil {
+		return err
+	}
+	if err := DeleteIf(ctx, lc.raw, r, cond); err != nil {
+		return err
+	}
+	return lc.update(ctx, r, "delete")
+}
+
+func (lc ListingCombinator) GetStream(ctx context.Context, r Reference) (io.ReadCloser, error) {
+	return GetStream(ctx, lc.raw, r)
 }
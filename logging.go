@@ -2,6 +2,7 @@ package sc
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"io"
 	"time"
@@ -52,16 +53,20 @@ func newLogRecord(method string, source Reference) (*LogRecord, Reference, error
 	return record, target, nil
 }
 
-func (c LoggingCombinator) log(record *LogRecord) error {
-	if err := c.list.Merge(c.listRef, record); err != nil {
+func (c LoggingCombinator) log(ctx context.Context, record *LogRecord) error {
+	if err := MergeContext(ctx, c.list, c.listRef, record); err != nil {
 		return err
 	}
 	return nil
 }
 
 func (c LoggingCombinator) Get(r Reference) (interface{}, error) {
+	return c.GetContext(context.Background(), r)
+}
+
+func (c LoggingCombinator) GetContext(ctx context.Context, r Reference) (interface{}, error) {
 	if r.URI().String() == c.listRef.URI().String() {
-		i, err := c.list.Get(c.listRef)
+		i, err := GetContext(ctx, c.list, c.listRef)
 		if err != nil {
 			return nil, err
 		}
@@ -86,39 +91,49 @@ func (c LoggingCombinator) Get(r Reference) (interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
-	i, err := c.storage.Get(target)
+	i, err := GetContext(ctx, c.storage, target)
 	if err != nil {
 		return nil, err
 	}
-	if err := c.log(record); err != nil {
+	if err := c.log(ctx, record); err != nil {
 		return nil, err
 	}
 	return i, err
 }
 
-type mutatorFunc func(Reference, interface{}) error
-
-func (c LoggingCombinator) update(r Reference, i interface{}, method string, mutator mutatorFunc) error {
+func (c LoggingCombinator) update(ctx context.Context, r Reference, i interface{}, method string, mutator contextMutator) error {
 	record, target, err := newLogRecord(method, r)
 	if err != nil {
 		return err
 	}
-	if err := mutator(target, i); err != nil {
+	if err := mutator(ctx, c.storage, target, i); err != nil {
 		return err
 	}
-	return c.log(record)
+	return c.log(ctx, record)
 }
 
 func (c LoggingCombinator) Put(r Reference, i interface{}) error {
-	return c.update(r, i, "put", c.storage.Put)
+	return c.PutContext(context.Background(), r, i)
+}
+
+func (c LoggingCombinator) PutContext(ctx context.Context, r Reference, i interface{}) error {
+	return c.update(ctx, r, i, "put", PutContext)
 }
 
 func (c LoggingCombinator) Merge(r Reference, i interface{}) error {
-	return c.update(r, i, "merge", c.storage.Merge)
+	return c.MergeContext(context.Background(), r, i)
+}
+
+func (c LoggingCombinator) MergeContext(ctx context.Context, r Reference, i interface{}) error {
+	return c.update(ctx, r, i, "merge", MergeContext)
 }
 
 func (c LoggingCombinator) Delete(r Reference) error {
-	return c.update(r, nil, "delete", func(r Reference, i interface{}) error {
-		return c.storage.Delete(r)
+	return c.DeleteContext(context.Background(), r)
+}
+
+func (c LoggingCombinator) DeleteContext(ctx context.Context, r Reference) error {
+	return c.update(ctx, r, nil, "delete", func(ctx context.Context, s StorageCombinator, r Reference, i interface{}) error {
+		return DeleteContext(ctx, s, r)
 	})
 }
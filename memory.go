@@ -1,14 +1,78 @@
 package sc
 
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/url"
+	"sync"
+)
+
 func NewMemory() *Memory {
 	return &Memory{m: make(map[string]interface{})}
 }
 
+// safe for concurrent use; snapshots share the underlying map
+// until the next write, which copies it
 type Memory struct {
+	mu     sync.RWMutex
+	m      map[string]interface{}
+	shared bool // m is also held by a snapshot
+}
+
+// MemorySnapshot is an immutable point-in-time copy of a Memory
+type MemorySnapshot struct {
 	m map[string]interface{}
 }
 
-func (mem Memory) Get(r Reference) (interface{}, error) {
+func (s MemorySnapshot) Len() int {
+	return len(s.m)
+}
+
+// Memory returns a new, independent store starting from the snapshot
+func (s MemorySnapshot) Memory() *Memory {
+	return &Memory{m: s.m, shared: true}
+}
+
+// Snapshot captures the current state in constant time
+func (mem *Memory) Snapshot() MemorySnapshot {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	mem.shared = true
+	return MemorySnapshot{m: mem.m}
+}
+
+// Restore rolls back to the snapshot, discarding everything since
+func (mem *Memory) Restore(s MemorySnapshot) {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	mem.m = s.m
+	mem.shared = true
+}
+
+// map that's safe to write, with lock held
+func (mem *Memory) writable() map[string]interface{} {
+	if mem.shared || mem.m == nil {
+		m := make(map[string]interface{}, len(mem.m))
+		for k, v := range mem.m {
+			m[k] = v
+		}
+		mem.m = m
+		mem.shared = false
+	}
+	return mem.m
+}
+
+func (mem *Memory) Get(r Reference) (interface{}, error) {
+	return mem.GetContext(context.Background(), r)
+}
+
+func (mem *Memory) GetContext(ctx context.Context, r Reference) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	mem.mu.RLock()
+	defer mem.mu.RUnlock()
 	i, ok := mem.m[key(r)]
 	if !ok {
 		return nil, NotFound
@@ -20,19 +84,150 @@ func key(r Reference) string {
 	return r.URI().String()
 }
 
-func (mem Memory) Put(r Reference, i interface{}) error {
-	mem.m[key(r)] = i
-	return nil
+func (mem *Memory) Put(r Reference, i interface{}) error {
+	return mem.PutContext(context.Background(), r, i)
+}
+
+func (mem *Memory) PutContext(ctx context.Context, r Reference, i interface{}) error {
+	return mem.PutIf(ctx, r, i, Conditions{})
+}
+
+// what we actually store: streams can only be read once, and callers
+// may reuse byte slices, either of which would corrupt snapshots
+func storable(i interface{}) (interface{}, error) {
+	switch t := i.(type) {
+	case io.Reader:
+		return Blob(t)
+	case []byte:
+		return append([]byte{}, t...), nil
+	default:
+		return i, nil
+	}
+}
+
+func (mem *Memory) Merge(r Reference, i interface{}) error {
+	return mem.MergeContext(context.Background(), r, i)
 }
 
-func (mem Memory) Merge(r Reference, i interface{}) error {
+func (mem *Memory) MergeContext(context.Context, Reference, interface{}) error {
 	return unimplemented(mem, "Merge")
 }
 
-func (mem Memory) Delete(r Reference) error {
+func (mem *Memory) Delete(r Reference) error {
+	return mem.DeleteContext(context.Background(), r)
+}
+
+func (mem *Memory) DeleteContext(ctx context.Context, r Reference) error {
+	return mem.DeleteIf(ctx, r, Conditions{})
+}
+
+// lists path-addressable keys, i.e., those without query or fragment,
+// having the same scheme and host as the prefix
+func (mem *Memory) List(ctx context.Context, prefix Reference, opts ListOptions) (*ListPage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	pu := prefix.URI()
+	var entries []Entry
+	mem.mu.RLock()
+	defer mem.mu.RUnlock()
+	for k, i := range mem.m {
+		e, err := keyEntry(pu, k, i)
+		if err != nil {
+			return nil, err
+		}
+		if e != nil {
+			entries = append(entries, *e)
+		}
+	}
+	return paginate(pu.Path, entries, opts), nil
+}
+
+// listing entry for a key, nil if it isn't path-addressable beneath the prefix
+func keyEntry(pu *url.URL, k string, i interface{}) (*Entry, error) {
+	u, err := url.Parse(k)
+	if err != nil {
+		return nil, err
+	}
+	if u.RawQuery != "" || u.Fragment != "" || u.Scheme != pu.Scheme || u.Host != pu.Host {
+		return nil, nil
+	}
+	return &Entry{
+		Path: u.Path,
+		Size: sizeOf(i),
+		base: pu,
+	}, nil
+}
+
+func (mem *Memory) Stat(ctx context.Context, r Reference) (*Metadata, error) {
+	i, err := mem.GetContext(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	return memoryMetadata(r, i)
+}
+
+func memoryMetadata(r Reference, i interface{}) (*Metadata, error) {
+	m := Metadata{
+		Size:        sizeOf(i),
+		ContentType: contentType(r.URI().Path),
+	}
+	if b, err := Blob(i); err == nil {
+		tag, err := etag(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		// structured values are as big as they're encoded
+		m.Size = int64(len(b))
+		m.ETag = tag
+	}
+	return &m, nil
+}
+
+// checks conditions with the lock held, so check and write are atomic
+func (mem *Memory) checkLocked(r Reference, cond Conditions) error {
+	if cond.IsZero() {
+		return nil
+	}
+	var m *Metadata
+	if i, ok := mem.m[key(r)]; ok {
+		var err error
+		if m, err = memoryMetadata(r, i); err != nil {
+			return err
+		}
+	}
+	return cond.check(r, m)
+}
+
+func (mem *Memory) PutIf(ctx context.Context, r Reference, i interface{}, cond Conditions) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	i, err := storable(i)
+	if err != nil {
+		return err
+	}
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	if err := mem.checkLocked(r, cond); err != nil {
+		return err
+	}
+	mem.writable()[key(r)] = i
+	return nil
+}
+
+func (mem *Memory) DeleteIf(ctx context.Context, r Reference, cond Conditions) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
 	if _, ok := mem.m[key(r)]; !ok {
 		return NotFound
 	}
-	delete(mem.m, key(r))
+	if err := mem.checkLocked(r, cond); err != nil {
+		return err
+	}
+	delete(mem.writable(), key(r))
 	return nil
 }
@@ -1,76 +1,357 @@
 package sc
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"sort"
 	"strings"
+	"sync"
 )
 
 // NewMultiplexer creates a switching storage combinator,
-// based on longest match to first path component
+// based on longest match of whole path segments to the map's keys,
+// e.g. "data" or "/archive/2020". if keys clean to the same prefix,
+// every operation fails saying so; NewMountTable reports that up front
 func NewMultiplexer(m map[string]StorageCombinator) *Multiplexer {
-	return &Multiplexer{m: m}
+	var mounts []Mount
+	for k, c := range m {
+		mounts = append(mounts, Mount{Prefix: k, C: c})
+	}
+	mux, err := NewMountTable(mounts...)
+	if err != nil {
+		return &Multiplexer{err: err}
+	}
+	return mux
+}
+
+// NewMountTable creates a switching storage combinator from explicit mounts
+func NewMountTable(mounts ...Mount) (*Multiplexer, error) {
+	var m Multiplexer
+	for _, mt := range mounts {
+		if err := m.Mount(mt); err != nil {
+			return nil, err
+		}
+	}
+	return &m, nil
 }
 
+// safe to mount and unmount while in use
 type Multiplexer struct {
-	m map[string]StorageCombinator
+	lock   sync.RWMutex
+	mounts []Mount // replaced, never modified, on mount or unmount
+	err    error   // why it couldn't be made, if it couldn't
 }
 
-func firstPathComponent(p string) string {
-	for _, p := range strings.Split(p, "/") {
-		if len(p) == 0 {
-			continue
+// Mount adds a mount, which mustn't already exist
+func (m *Multiplexer) Mount(mt Mount) error {
+	mt = mt.clean()
+	if mt.C == nil {
+		return fmt.Errorf("no combinator for mount %v", mt)
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	for _, x := range m.mounts {
+		if x.key() == mt.key() {
+			return fmt.Errorf("duplicate mount %v", mt)
+		}
+	}
+	mounts := append(append([]Mount{}, m.mounts...), mt)
+	sort.Slice(mounts, func(i, j int) bool {
+		return mounts[i].key() < mounts[j].key()
+	})
+	m.mounts = mounts
+	return nil
+}
+
+// Unmount removes the mount with the same prefix, scheme and host as mt;
+// operations already in progress on it are unaffected
+func (m *Multiplexer) Unmount(mt Mount) error {
+	mt = mt.clean()
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var mounts []Mount
+	for _, x := range m.mounts {
+		if x.key() != mt.key() {
+			mounts = append(mounts, x)
 		}
+	}
+	if len(mounts) == len(m.mounts) {
+		return fmt.Errorf("no mount %s (%w)", mt.key(), NotFound)
+	}
+	m.mounts = mounts
+	return nil
+}
+
+// Mounts returns the current mounts, ordered by mount point
+func (m *Multiplexer) Mounts() []Mount {
+	table, _ := m.table()
+	return append([]Mount{}, table...)
+}
+
+// current mounts, not to be modified
+func (m *Multiplexer) table() ([]Mount, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.mounts, m.err
+}
+
+// Mount routes references to a combinator
+type Mount struct {
+	// whole-segment path prefix served, "/" for everything
+	Prefix string
+	// if non-empty, only references with this scheme and host are served
+	Scheme, Host string
+	// remove the prefix from paths before delegating
+	Strip bool
+	C     StorageCombinator
+}
+
+func (mt Mount) String() string {
+	return fmt.Sprintf("%s -> %T", mt.key(), mt.C)
+}
+
+func (mt Mount) clean() Mount {
+	mt.Prefix = listPath(mt.Prefix)
+	mt.Scheme = strings.ToLower(mt.Scheme)
+	mt.Host = strings.ToLower(mt.Host)
+	return mt
+}
+
+// identifies the mount point
+func (mt Mount) key() string {
+	u := url.URL{Scheme: mt.Scheme, Host: mt.Host, Path: mt.Prefix}
+	return u.String()
+}
+
+// whether the mount serves the scheme and host of u
+func (mt Mount) serves(u *url.URL) bool {
+	return (mt.Scheme == "" || strings.EqualFold(mt.Scheme, u.Scheme)) &&
+		(mt.Host == "" || strings.EqualFold(mt.Host, u.Host))
+}
+
+// whether p is the prefix or beneath it
+func (mt Mount) contains(p string) bool {
+	return p == mt.Prefix || beneath(mt.Prefix, p)
+}
+
+// higher is more specific: mounts for a particular scheme or host
+// take precedence over any without, then longer prefixes win
+func (mt Mount) rank() int {
+	n := 0
+	if mt.Prefix != "/" {
+		n = strings.Count(mt.Prefix, "/")
+	}
+	if mt.Scheme != "" {
+		n += 2 << 16
+	}
+	if mt.Host != "" {
+		n += 1 << 16
+	}
+	return n
+}
+
+// the reference as the mounted combinator sees it
+func (mt Mount) rewrite(r Reference) Reference {
+	if !mt.Strip || mt.Prefix == "/" {
+		return r
+	}
+	u := *r.URI()
+	u.Path = listPath(strings.TrimPrefix(listPath(u.Path), mt.Prefix))
+	u.RawPath = ""
+	return NewURI(&u)
+}
+
+// a path from the mounted combinator as the multiplexer sees it
+func (mt Mount) unrewrite(p string) string {
+	if !mt.Strip {
 		return p
 	}
-	return ""
+	return path.Join(mt.Prefix, p)
 }
 
-func (m Multiplexer) find(p string) (StorageCombinator, error) {
-	first := firstPathComponent(p)
-	var best string
-	for k := range m.m {
-		if !strings.HasPrefix(first, k) {
+// the mount serving u
+func (m *Multiplexer) route(u *url.URL) (*Mount, error) {
+	table, err := m.table()
+	if err != nil {
+		return nil, err
+	}
+	return route(table, u)
+}
+
+func route(mounts []Mount, u *url.URL) (*Mount, error) {
+	p := listPath(u.Path)
+	var best *Mount
+	for i, mt := range mounts {
+		if !mt.serves(u) || !mt.contains(p) {
 			continue
 		}
-		if len(k) > len(best) {
-			best = k
+		if best == nil || mt.rank() > best.rank() {
+			best = &mounts[i]
 		}
 	}
-	c, ok := m.m[best]
-	if !ok {
-		return nil, fmt.Errorf("unsupported path: %q (%w)", p, NotFound)
+	if best == nil {
+		return nil, fmt.Errorf("unsupported path: %q (%w)", u, NotFound)
+	}
+	return best, nil
+}
+
+// the combinator serving a reference, and the reference as it should see it
+func (m *Multiplexer) find(r Reference) (StorageCombinator, Reference, error) {
+	mt, err := m.route(r.URI())
+	if err != nil {
+		return nil, nil, err
 	}
-	return c, nil
+	return mt.C, mt.rewrite(r), nil
 }
 
-func (m Multiplexer) Get(r Reference) (interface{}, error) {
-	c, err := m.find(r.URI().Path)
+func (m *Multiplexer) Get(r Reference) (interface{}, error) {
+	return m.GetContext(context.Background(), r)
+}
+
+func (m *Multiplexer) GetContext(ctx context.Context, r Reference) (interface{}, error) {
+	c, r, err := m.find(r)
 	if err != nil {
 		return nil, err
 	}
-	return c.Get(r)
+	return GetContext(ctx, c, r)
+}
+
+func (m *Multiplexer) Put(r Reference, i interface{}) error {
+	return m.PutContext(context.Background(), r, i)
+}
+
+func (m *Multiplexer) PutContext(ctx context.Context, r Reference, i interface{}) error {
+	c, r, err := m.find(r)
+	if err != nil {
+		return err
+	}
+	return PutContext(ctx, c, r, i)
 }
 
-func (m Multiplexer) Put(r Reference, i interface{}) error {
-	c, err := m.find(r.URI().Path)
+func (m *Multiplexer) Merge(r Reference, i interface{}) error {
+	return m.MergeContext(context.Background(), r, i)
+}
+
+func (m *Multiplexer) MergeContext(ctx context.Context, r Reference, i interface{}) error {
+	c, r, err := m.find(r)
+	if err != nil {
+		return err
+	}
+	return MergeContext(ctx, c, r, i)
+}
+
+func (m *Multiplexer) Delete(r Reference) error {
+	return m.DeleteContext(context.Background(), r)
+}
+
+func (m *Multiplexer) DeleteContext(ctx context.Context, r Reference) error {
+	c, r, err := m.find(r)
 	if err != nil {
 		return err
 	}
-	return c.Put(r, i)
+	return DeleteContext(ctx, c, r)
+}
+
+// delegates to the mount serving the prefix, or if other mounts lie
+// beneath it, fans out across all of them and merges their listings,
+// with mount points appearing as directories
+func (m *Multiplexer) List(ctx context.Context, prefix Reference, opts ListOptions) (*ListPage, error) {
+	u := prefix.URI()
+	dir := listPath(u.Path)
+	table, err := m.table()
+	if err != nil {
+		return nil, err
+	}
+	var mounts []Mount
+	nested := false
+	for _, mt := range table {
+		switch {
+		case !mt.serves(u):
+		case beneath(dir, mt.Prefix):
+			nested = true
+			mounts = append(mounts, mt)
+		case mt.contains(dir):
+			mounts = append(mounts, mt)
+		}
+	}
+	if !nested {
+		mt, err := route(table, u)
+		if err != nil {
+			return nil, err
+		}
+		page, err := List(ctx, mt.C, mt.rewrite(prefix), opts)
+		if err != nil {
+			return nil, err
+		}
+		for i, e := range page.Entries {
+			page.Entries[i].Path = mt.unrewrite(e.Path)
+		}
+		return page, nil
+	}
+	var base *url.URL
+	if u.Scheme != "" || u.Host != "" {
+		base = &url.URL{Scheme: u.Scheme, Host: u.Host}
+	}
+	var entries []Entry
+	for _, mt := range mounts {
+		var lp Reference = prefix
+		if beneath(dir, mt.Prefix) {
+			lp = NewURI(&url.URL{Scheme: u.Scheme, Host: u.Host, Path: mt.Prefix})
+			entries = append(entries, Entry{Path: mt.Prefix, IsDir: true, base: base})
+		}
+		list, err := ListAll(ctx, mt.C, mt.rewrite(lp), opts.Recursive)
+		if errors.Is(err, NotSupported) || errors.Is(err, NotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		for _, e := range list {
+			e.Path = mt.unrewrite(e.Path)
+			// only keep what this mount would actually serve
+			eu := url.URL{Scheme: u.Scheme, Host: u.Host, Path: e.Path}
+			if x, err := route(table, &eu); err == nil && x.key() == mt.key() {
+				entries = append(entries, e)
+			}
+		}
+	}
+	return paginate(dir, entries, opts), nil
+}
+
+func (m *Multiplexer) Stat(ctx context.Context, r Reference) (*Metadata, error) {
+	c, r, err := m.find(r)
+	if err != nil {
+		return nil, err
+	}
+	return Stat(ctx, c, r)
 }
 
-func (m Multiplexer) Merge(r Reference, i interface{}) error {
-	c, err := m.find(r.URI().Path)
+func (m *Multiplexer) PutIf(ctx context.Context, r Reference, i interface{}, cond Conditions) error {
+	c, r, err := m.find(r)
 	if err != nil {
 		return err
 	}
-	return c.Merge(r, i)
+	return PutIf(ctx, c, r, i, cond)
 }
 
-func (m Multiplexer) Delete(r Reference) error {
-	c, err := m.find(r.URI().Path)
+func (m *Multiplexer) DeleteIf(ctx context.Context, r Reference, cond Conditions) error {
+	c, r, err := m.find(r)
 	if err != nil {
 		return err
 	}
-	return c.Delete(r)
+	return DeleteIf(ctx, c, r, cond)
+}
+
+func (m *Multiplexer) GetStream(ctx context.Context, r Reference) (io.ReadCloser, error) {
+	c, r, err := m.find(r)
+	if err != nil {
+		return nil, err
+	}
+	return GetStream(ctx, c, r)
 }
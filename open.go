@@ -0,0 +1,345 @@
+package sc
+
+import (
+	"crypto/tls"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Driver opens a combinator for a uri of the scheme it's registered under
+type Driver func(u *url.URL) (StorageCombinator, error)
+
+var drivers = struct {
+	sync.RWMutex
+	m map[string]Driver
+}{m: make(map[string]Driver)}
+
+// RegisterScheme makes a driver available to Open,
+// panicking if the scheme is already registered
+func RegisterScheme(scheme string, d Driver) {
+	scheme = strings.ToLower(scheme)
+	drivers.Lock()
+	defer drivers.Unlock()
+	if _, ok := drivers.m[scheme]; ok {
+		panic(fmt.Sprintf("scheme %q already registered", scheme))
+	}
+	drivers.m[scheme] = d
+}
+
+// Schemes returns the registered schemes
+func Schemes() []string {
+	drivers.RLock()
+	defer drivers.RUnlock()
+	var out []string
+	for k := range drivers.m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Open returns a combinator for a uri, using the driver for its scheme;
+// the built-in drivers are:
+//
+//	file:///path/to/dir                    filesystem rooted at the path
+//	mem://[name][?maxEntries=n&maxBytes=n&policy=lru|lfu]
+//	                                       memory, shared by name within the process,
+//	                                       and bounded if any limits are given
+//	s3://bucket[/prefix][?region=r&profile=p&stream=true&partSize=n&concurrency=n&resumable=true]
+//	sftp://[user[:password]@]host[:port][?keyFile=f&agent=true&knownHosts=f1,f2]
+//	                                       password otherwise from $SFTP_PASSWORD,
+//	                                       or the variable named by passwordEnv
+//	ftp[s]://[user[:password]@]host[:port][?implicit=true&epsv=false&insecureSkipVerify=true]
+//	                                       anonymous without a user, password otherwise
+//	                                       from $FTP_PASSWORD, or the variable named by
+//	                                       passwordEnv; ftps is explicit tls unless implicit
+//	http[s]://host[/base]                  remote stack served by a Handler
+//	db://driver?dsn=...                    dsn otherwise from $<DRIVER>_DSN,
+//	                                       or the variable named by dsnEnv
+func Open(uri string) (StorageCombinator, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	drivers.RLock()
+	d, ok := drivers.m[strings.ToLower(u.Scheme)]
+	drivers.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no driver for scheme %q", u.Scheme)
+	}
+	c, err := d(u)
+	if err != nil {
+		return nil, fmt.Errorf("can't open %s: %w", redacted(u), err)
+	}
+	return c, nil
+}
+
+// for error messages
+func redacted(u *url.URL) string {
+	x := *u
+	if _, ok := x.User.Password(); ok {
+		x.User = url.UserPassword(x.User.Username(), "xxxxx")
+	}
+	q := x.Query()
+	if q.Get("dsn") != "" {
+		q.Set("dsn", "xxxxx")
+		x.RawQuery = q.Encode()
+	}
+	return x.String()
+}
+
+// the environment variable named by a query parameter, or else a default one
+func fromEnv(q url.Values, envKey, defaultVar string) string {
+	if v := q.Get(envKey); v != "" {
+		return os.Getenv(v)
+	}
+	return os.Getenv(defaultVar)
+}
+
+var namedMemories = struct {
+	sync.Mutex
+	m map[string]StorageCombinator
+}{m: make(map[string]StorageCombinator)}
+
+func openMemory(u *url.URL) (StorageCombinator, error) {
+	q := u.Query()
+	var opts BoundedOptions
+	if s := q.Get("maxBytes"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad maxBytes: %w", err)
+		}
+		opts.MaxBytes = n
+	}
+	if s := q.Get("maxEntries"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, fmt.Errorf("bad maxEntries: %w", err)
+		}
+		opts.MaxEntries = n
+	}
+	switch strings.ToLower(q.Get("policy")) {
+	case "", "lru":
+	case "lfu":
+		opts.Policy = LFU
+	default:
+		return nil, fmt.Errorf("unknown eviction policy %q", q.Get("policy"))
+	}
+	open := func() (StorageCombinator, error) {
+		if opts == (BoundedOptions{}) {
+			return NewMemory(), nil
+		}
+		return NewBoundedMemory(opts)
+	}
+	if u.Host == "" {
+		return open()
+	}
+	namedMemories.Lock()
+	defer namedMemories.Unlock()
+	if c, ok := namedMemories.m[u.Host]; ok {
+		return c, nil
+	}
+	c, err := open()
+	if err != nil {
+		return nil, err
+	}
+	namedMemories.m[u.Host] = c
+	return c, nil
+}
+
+func openFile(u *url.URL) (StorageCombinator, error) {
+	if u.Host != "" && u.Host != "localhost" {
+		return nil, fmt.Errorf("remote file host %q", u.Host)
+	}
+	p := u.Path
+	if u.Opaque != "" {
+		// relative, as in file:dir/x
+		p = u.Opaque
+	}
+	if p == "" {
+		return nil, fmt.Errorf("needs path")
+	}
+	return NewFileSystem(p)
+}
+
+func openS3(u *url.URL) (StorageCombinator, error) {
+	q := u.Query()
+	opts := session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+		Profile:           q.Get("profile"),
+	}
+	if r := q.Get("region"); r != "" {
+		opts.Config.Region = aws.String(r)
+	}
+	if e := q.Get("endpoint"); e != "" {
+		opts.Config.Endpoint = aws.String(e)
+		opts.Config.S3ForcePathStyle = aws.Bool(true)
+	}
+	var stream bool
+	var uploads S3UploadOptions
+	for k, b := range map[string]*bool{"stream": &stream, "resumable": &uploads.Resumable} {
+		if s := q.Get(k); s != "" {
+			v, err := strconv.ParseBool(s)
+			if err != nil {
+				return nil, fmt.Errorf("bad %s: %w", k, err)
+			}
+			*b = v
+		}
+	}
+	if s := q.Get("partSize"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad partSize: %w", err)
+		}
+		uploads.PartSize = n
+	}
+	if s := q.Get("concurrency"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, fmt.Errorf("bad concurrency: %w", err)
+		}
+		uploads.Concurrency = n
+	}
+	p, err := session.NewSessionWithOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	return NewS3KeyValueWithOptions(u.Host, removeLeadingSlashes(u.Path), stream, s3.New(p), uploads)
+}
+
+func openSFTP(u *url.URL) (StorageCombinator, error) {
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("needs host")
+	}
+	if p := u.Path; p != "" && p != "/" {
+		return nil, fmt.Errorf("paths are absolute on the server, so can't root at %q", p)
+	}
+	q := u.Query()
+	opts := SFTPOptions{
+		Host:    u.Host,
+		User:    os.Getenv("USER"),
+		KeyFile: q.Get("keyFile"),
+	}
+	if u.User != nil {
+		opts.User = u.User.Username()
+	}
+	if password, ok := u.User.Password(); ok {
+		opts.Password = password
+	} else {
+		opts.Password = fromEnv(q, "passwordEnv", "SFTP_PASSWORD")
+	}
+	if s := q.Get("knownHosts"); s != "" {
+		opts.KnownHosts = strings.Split(s, ",")
+	}
+	for k, b := range map[string]*bool{"agent": &opts.Agent, "insecureIgnoreHostKey": &opts.InsecureIgnoreHostKey} {
+		if s := q.Get(k); s != "" {
+			v, err := strconv.ParseBool(s)
+			if err != nil {
+				return nil, fmt.Errorf("bad %s: %w", k, err)
+			}
+			*b = v
+		}
+	}
+	return NewSFTP(opts)
+}
+
+func openFTP(u *url.URL) (StorageCombinator, error) {
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("needs host")
+	}
+	if p := u.Path; p != "" && p != "/" {
+		return nil, fmt.Errorf("paths are relative to the login directory, so can't root at %q", p)
+	}
+	q := u.Query()
+	opts := FTPOptions{Host: u.Host}
+	if u.User != nil {
+		opts.User = u.User.Username()
+		if password, ok := u.User.Password(); ok {
+			opts.Password = password
+		} else {
+			opts.Password = fromEnv(q, "passwordEnv", "FTP_PASSWORD")
+		}
+	}
+	var implicit, insecure bool
+	epsv := true
+	for k, b := range map[string]*bool{"implicit": &implicit, "epsv": &epsv, "insecureSkipVerify": &insecure} {
+		if s := q.Get(k); s != "" {
+			v, err := strconv.ParseBool(s)
+			if err != nil {
+				return nil, fmt.Errorf("bad %s: %w", k, err)
+			}
+			*b = v
+		}
+	}
+	opts.DisableEPSV = !epsv
+	if strings.EqualFold(u.Scheme, "ftps") {
+		opts.Security = FTPExplicitTLS
+		if implicit {
+			opts.Security = FTPImplicitTLS
+		}
+		opts.TLSConfig = &tls.Config{ServerName: u.Hostname(), InsecureSkipVerify: insecure}
+	} else if implicit || insecure {
+		return nil, fmt.Errorf("tls options need ftps")
+	}
+	return NewFTP(opts)
+}
+
+func openRemote(u *url.URL) (StorageCombinator, error) {
+	return NewRemote(u.String(), nil)
+}
+
+func openDB(u *url.URL) (StorageCombinator, error) {
+	driver := u.Host
+	if driver == "" {
+		return nil, fmt.Errorf("needs driver")
+	}
+	q := u.Query()
+	dsn := q.Get("dsn")
+	if dsn == "" {
+		dsn = fromEnv(q, "dsnEnv", strings.ToUpper(driver)+"_DSN")
+	}
+	if dsn == "" {
+		return nil, fmt.Errorf("needs dsn")
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	return NewDatabaseCombinator(db), nil
+}
+
+func init() {
+	for k, d := range map[string]Driver{
+		"mem":   openMemory,
+		"file":  openFile,
+		"s3":    openS3,
+		"sftp":  openSFTP,
+		"ftp":   openFTP,
+		"ftps":  openFTP,
+		"db":    openDB,
+		"http":  openRemote,
+		"https": openRemote,
+	} {
+		RegisterScheme(k, d)
+	}
+	RegisterFactory("open", func(s *Spec, of []StorageCombinator) (StorageCombinator, error) {
+		var o struct{ URI string }
+		if err := s.Decode(&o); err != nil {
+			return nil, err
+		}
+		if len(of) > 0 {
+			return nil, fmt.Errorf("doesn't wrap anything")
+		}
+		return Open(o.URI)
+	})
+}
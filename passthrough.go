@@ -1,6 +1,10 @@
 package sc
 
-import "log"
+import (
+	"context"
+	"io"
+	"log"
+)
 
 func NewPassthrough(msg string, c StorageCombinator) *Passthrough {
 	return &Passthrough{
@@ -15,30 +19,30 @@ type Passthrough struct {
 }
 
 type (
-	f0 func(Reference) error
-	f1 func(Reference) (interface{}, error)
-	f2 func(Reference, interface{}) error
+	f0 func(context.Context, StorageCombinator, Reference) error
+	f1 func(context.Context, StorageCombinator, Reference) (interface{}, error)
+	f2 func(context.Context, StorageCombinator, Reference, interface{}) error
 	f3 func(string) (Reference, error)
 )
 
-func (pt Passthrough) debug0(m string, f f0, r Reference) error {
-	err := f(r)
+func (pt Passthrough) debug0(ctx context.Context, m string, f f0, r Reference) error {
+	err := f(ctx, pt.c, r)
 	if pt.m != "" {
 		log.Printf("%s.%s(%s) = %v", pt.m, m, r.URI(), err)
 	}
 	return err
 }
 
-func (pt Passthrough) debug1(m string, f f1, r Reference) (interface{}, error) {
-	i, err := f(r)
+func (pt Passthrough) debug1(ctx context.Context, m string, f f1, r Reference) (interface{}, error) {
+	i, err := f(ctx, pt.c, r)
 	if pt.m != "" {
 		log.Printf("%s.%s(%s) = (%T,%v)", pt.m, m, r.URI(), i, err)
 	}
 	return i, err
 }
 
-func (pt Passthrough) debug2(m string, f f2, r Reference, i interface{}) error {
-	err := f(r, i)
+func (pt Passthrough) debug2(ctx context.Context, m string, f f2, r Reference, i interface{}) error {
+	err := f(ctx, pt.c, r, i)
 	if pt.m != "" {
 		log.Printf("%s.%s(%s,%T) = %v", pt.m, m, r.URI(), i, err)
 	}
@@ -46,17 +50,73 @@ func (pt Passthrough) debug2(m string, f f2, r Reference, i interface{}) error {
 }
 
 func (pt Passthrough) Get(r Reference) (interface{}, error) {
-	return pt.debug1("Get", pt.c.Get, r)
+	return pt.GetContext(context.Background(), r)
+}
+
+func (pt Passthrough) GetContext(ctx context.Context, r Reference) (interface{}, error) {
+	return pt.debug1(ctx, "Get", GetContext, r)
 }
 
 func (pt Passthrough) Put(r Reference, i interface{}) error {
-	return pt.debug2("Put", pt.c.Put, r, i)
+	return pt.PutContext(context.Background(), r, i)
+}
+
+func (pt Passthrough) PutContext(ctx context.Context, r Reference, i interface{}) error {
+	return pt.debug2(ctx, "Put", PutContext, r, i)
 }
 
 func (pt Passthrough) Merge(r Reference, i interface{}) error {
-	return pt.debug2("Merge", pt.c.Merge, r, i)
+	return pt.MergeContext(context.Background(), r, i)
+}
+
+func (pt Passthrough) MergeContext(ctx context.Context, r Reference, i interface{}) error {
+	return pt.debug2(ctx, "Merge", MergeContext, r, i)
 }
 
 func (pt Passthrough) Delete(r Reference) error {
-	return pt.debug0("Delete", pt.c.Delete, r)
+	return pt.DeleteContext(context.Background(), r)
+}
+
+func (pt Passthrough) DeleteContext(ctx context.Context, r Reference) error {
+	return pt.debug0(ctx, "Delete", DeleteContext, r)
+}
+
+func (pt Passthrough) List(ctx context.Context, prefix Reference, opts ListOptions) (*ListPage, error) {
+	page, err := List(ctx, pt.c, prefix, opts)
+	if pt.m != "" {
+		log.Printf("%s.List(%s) = (%T,%v)", pt.m, prefix.URI(), page, err)
+	}
+	return page, err
+}
+
+func (pt Passthrough) Stat(ctx context.Context, r Reference) (*Metadata, error) {
+	m, err := Stat(ctx, pt.c, r)
+	if pt.m != "" {
+		log.Printf("%s.Stat(%s) = (%v,%v)", pt.m, r.URI(), m, err)
+	}
+	return m, err
+}
+
+func (pt Passthrough) PutIf(ctx context.Context, r Reference, i interface{}, cond Conditions) error {
+	err := PutIf(ctx, pt.c, r, i, cond)
+	if pt.m != "" {
+		log.Printf("%s.PutIf(%s,%T,%+v) = %v", pt.m, r.URI(), i, cond, err)
+	}
+	return err
+}
+
+func (pt Passthrough) DeleteIf(ctx context.Context, r Reference, cond Conditions) error {
+	err := DeleteIf(ctx, pt.c, r, cond)
+	if pt.m != "" {
+		log.Printf("%s.DeleteIf(%s,%+v) = %v", pt.m, r.URI(), cond, err)
+	}
+	return err
+}
+
+func (pt Passthrough) GetStream(ctx context.Context, r Reference) (io.ReadCloser, error) {
+	rc, err := GetStream(ctx, pt.c, r)
+	if pt.m != "" {
+		log.Printf("%s.GetStream(%s) = (%T,%v)", pt.m, r.URI(), rc, err)
+	}
+	return rc, err
 }
@@ -1,5 +1,7 @@
 package sc
 
+import "context"
+
 type ProgrammaticCombinator struct {
 	f RefFunc
 }
@@ -11,14 +13,36 @@ func NewProgrammatic(f RefFunc) *ProgrammaticCombinator {
 }
 
 func (c ProgrammaticCombinator) Get(r Reference) (interface{}, error) {
+	return c.GetContext(context.Background(), r)
+}
+
+func (c ProgrammaticCombinator) GetContext(ctx context.Context, r Reference) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	return c.f(r)
 }
-func (c ProgrammaticCombinator) Put(Reference, interface{}) error {
+
+func (c ProgrammaticCombinator) Put(r Reference, i interface{}) error {
+	return c.PutContext(context.Background(), r, i)
+}
+
+func (c ProgrammaticCombinator) PutContext(context.Context, Reference, interface{}) error {
 	return unimplemented(c, "Put")
 }
-func (c ProgrammaticCombinator) Delete(Reference) error {
+
+func (c ProgrammaticCombinator) Delete(r Reference) error {
+	return c.DeleteContext(context.Background(), r)
+}
+
+func (c ProgrammaticCombinator) DeleteContext(context.Context, Reference) error {
 	return unimplemented(c, "Delete")
 }
-func (c ProgrammaticCombinator) Merge(Reference, interface{}) error {
+
+func (c ProgrammaticCombinator) Merge(r Reference, i interface{}) error {
+	return c.MergeContext(context.Background(), r, i)
+}
+
+func (c ProgrammaticCombinator) MergeContext(context.Context, Reference, interface{}) error {
 	return unimplemented(c, "Merge")
 }
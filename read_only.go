@@ -1,6 +1,10 @@
 package sc
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"io"
+)
 
 type ReadOnly struct {
 	c StorageCombinator
@@ -13,17 +17,53 @@ func NewReadOnly(c StorageCombinator) *ReadOnly {
 var ReadOnlyError = errors.New("read only")
 
 func (ro ReadOnly) Get(r Reference) (interface{}, error) {
-	return ro.c.Get(r)
+	return ro.GetContext(context.Background(), r)
+}
+
+func (ro ReadOnly) GetContext(ctx context.Context, r Reference) (interface{}, error) {
+	return GetContext(ctx, ro.c, r)
 }
 
 func (ro ReadOnly) Put(Reference, interface{}) error {
 	return ReadOnlyError
 }
 
+func (ro ReadOnly) PutContext(context.Context, Reference, interface{}) error {
+	return ReadOnlyError
+}
+
 func (ro ReadOnly) Delete(Reference) error {
 	return ReadOnlyError
 }
 
+func (ro ReadOnly) DeleteContext(context.Context, Reference) error {
+	return ReadOnlyError
+}
+
 func (ro ReadOnly) Merge(Reference, interface{}) error {
 	return ReadOnlyError
 }
+
+func (ro ReadOnly) MergeContext(context.Context, Reference, interface{}) error {
+	return ReadOnlyError
+}
+
+func (ro ReadOnly) List(ctx context.Context, prefix Reference, opts ListOptions) (*ListPage, error) {
+	return List(ctx, ro.c, prefix, opts)
+}
+
+func (ro ReadOnly) Stat(ctx context.Context, r Reference) (*Metadata, error) {
+	return Stat(ctx, ro.c, r)
+}
+
+func (ro ReadOnly) PutIf(context.Context, Reference, interface{}, Conditions) error {
+	return ReadOnlyError
+}
+
+func (ro ReadOnly) DeleteIf(context.Context, Reference, Conditions) error {
+	return ReadOnlyError
+}
+
+func (ro ReadOnly) GetStream(ctx context.Context, r Reference) (io.ReadCloser, error) {
+	return GetStream(ctx, ro.c, r)
+}
@@ -0,0 +1,240 @@
+package sc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Remote is a combinator for a stack served by a Handler in another
+// process, with references addressed by path beneath a base url
+type Remote struct {
+	base   *url.URL
+	client *http.Client
+}
+
+// NewRemote uses http.DefaultClient if client is nil
+func NewRemote(base string, client *http.Client) (*Remote, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("not an http url: %q", base)
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Remote{base: u, client: client}, nil
+}
+
+// the url for a reference, with its own query merged with the
+// handler's parameters, which take precedence
+func (rm Remote) url(r Reference, q url.Values) string {
+	u := *rm.base
+	ru := r.URI()
+	u.Path = path.Join("/", u.Path, ru.Path)
+	if strings.HasSuffix(ru.Path, "/") && !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	u.RawPath = ""
+	merged := ru.Query()
+	for k, v := range q {
+		merged[k] = v
+	}
+	if ru.Fragment != "" {
+		merged.Set("fragment", ru.Fragment)
+	}
+	u.RawQuery = merged.Encode()
+	return u.String()
+}
+
+// makes a request, returning the response only if it succeeded
+func (rm Remote) do(ctx context.Context, method string, r Reference, q url.Values, body interface{}, header http.Header) (*http.Response, error) {
+	var rd io.Reader
+	switch t := body.(type) {
+	case nil:
+	case []byte:
+		rd = bytes.NewReader(t)
+	case string:
+		rd = strings.NewReader(t)
+	default:
+		rc, err := Reader(t)
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		rd = rc
+	}
+	req, err := http.NewRequestWithContext(ctx, method, rm.url(r, q), rd)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := rm.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		return nil, responseError(r, resp)
+	}
+	return resp, nil
+}
+
+// an error for an unsuccessful response, wrapping the sentinel its status implies
+func responseError(r Reference, resp *http.Response) error {
+	buf, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	msg := strings.TrimSpace(string(buf))
+	if msg == "" {
+		msg = resp.Status
+	}
+	var sentinel error
+	switch resp.StatusCode {
+	case http.StatusNotFound, http.StatusGone:
+		sentinel = NotFound
+	case http.StatusMethodNotAllowed:
+		sentinel = ReadOnlyError
+	case http.StatusPreconditionFailed:
+		sentinel = PreconditionFailed
+	case http.StatusNotImplemented:
+		sentinel = NotSupported
+	default:
+		return fmt.Errorf("bad status %q for %v: %s", resp.Status, r, msg)
+	}
+	return fmt.Errorf("%w (%v: %s)", sentinel, r, msg)
+}
+
+func (rm Remote) Get(r Reference) (interface{}, error) {
+	return rm.GetContext(context.Background(), r)
+}
+
+func (rm Remote) GetContext(ctx context.Context, r Reference) (interface{}, error) {
+	rc, err := rm.GetStream(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
+
+func (rm Remote) GetStream(ctx context.Context, r Reference) (io.ReadCloser, error) {
+	resp, err := rm.do(ctx, http.MethodGet, r, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// sends and discards the response
+func (rm Remote) send(ctx context.Context, method string, r Reference, i interface{}, header http.Header) error {
+	resp, err := rm.do(ctx, method, r, nil, i, header)
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	return resp.Body.Close()
+}
+
+func (rm Remote) Put(r Reference, i interface{}) error {
+	return rm.PutContext(context.Background(), r, i)
+}
+
+func (rm Remote) PutContext(ctx context.Context, r Reference, i interface{}) error {
+	return rm.send(ctx, http.MethodPut, r, i, nil)
+}
+
+func (rm Remote) Delete(r Reference) error {
+	return rm.DeleteContext(context.Background(), r)
+}
+
+func (rm Remote) DeleteContext(ctx context.Context, r Reference) error {
+	return rm.send(ctx, http.MethodDelete, r, nil, nil)
+}
+
+func (rm Remote) Merge(r Reference, i interface{}) error {
+	return rm.MergeContext(context.Background(), r, i)
+}
+
+func (rm Remote) MergeContext(ctx context.Context, r Reference, i interface{}) error {
+	return rm.send(ctx, http.MethodPatch, r, i, nil)
+}
+
+func conditionHeader(cond Conditions) http.Header {
+	h := make(http.Header)
+	quote := func(s string) string {
+		if s == "*" {
+			return s
+		}
+		return strconv.Quote(s)
+	}
+	if cond.IfMatch != "" {
+		h.Set("If-Match", quote(cond.IfMatch))
+	}
+	if cond.IfNoneMatch != "" {
+		h.Set("If-None-Match", quote(cond.IfNoneMatch))
+	}
+	return h
+}
+
+func (rm Remote) PutIf(ctx context.Context, r Reference, i interface{}, cond Conditions) error {
+	return rm.send(ctx, http.MethodPut, r, i, conditionHeader(cond))
+}
+
+func (rm Remote) DeleteIf(ctx context.Context, r Reference, cond Conditions) error {
+	return rm.send(ctx, http.MethodDelete, r, nil, conditionHeader(cond))
+}
+
+func (rm Remote) List(ctx context.Context, prefix Reference, opts ListOptions) (*ListPage, error) {
+	q := url.Values{"list": {""}}
+	if opts.Token != "" {
+		q.Set("token", opts.Token)
+	}
+	if opts.Limit > 0 {
+		q.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	if opts.Recursive {
+		q.Set("recursive", "true")
+	}
+	resp, err := rm.do(ctx, http.MethodGet, prefix, q, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var page ListPage
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+func (rm Remote) Stat(ctx context.Context, r Reference) (*Metadata, error) {
+	resp, err := rm.do(ctx, http.MethodHead, r, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	m := Metadata{
+		Size:        resp.ContentLength,
+		ContentType: resp.Header.Get("Content-Type"),
+		ETag:        strings.Trim(resp.Header.Get("ETag"), `"`),
+	}
+	if m.Size < 0 {
+		return nil, fmt.Errorf("%w (size of %v unknown)", NotSupported, r)
+	}
+	if t, err := time.Parse(http.TimeFormat, resp.Header.Get("Last-Modified")); err == nil {
+		m.ModTime = t
+	}
+	return &m, nil
+}
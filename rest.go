@@ -0,0 +1,495 @@
+package sc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultRESTRetries    = 3
+	DefaultRESTMinBackoff = 500 * time.Millisecond
+	DefaultRESTMaxBackoff = 30 * time.Second
+	DefaultRESTMaxPages   = 100
+)
+
+type Pagination string
+
+const (
+	NoPagination Pagination = ""
+	// follow rel="next" in the link header
+	LinkPagination Pagination = "link"
+	// pass CursorField of each page as CursorParam for the next
+	CursorPagination Pagination = "cursor"
+	// follow a url in NextField of each page
+	NextURLPagination Pagination = "next"
+)
+
+type RESTOptions struct {
+	// request url, where {path} is the reference's path without its leading
+	// slash and {0}, {1}, ... are its segments, each escaped; e.g.
+	// "https://api.example.com/v1/{path}". the reference's query is added
+	URL string
+	// extra request headers
+	Headers map[string]string
+
+	// api key, otherwise read from the environment variable KeyEnv
+	Key    string
+	KeyEnv string
+	// sent in this header, after KeyPrefix (e.g. "Bearer "),
+	KeyHeader string
+	KeyPrefix string
+	// or else in this query parameter
+	KeyParam string
+
+	Pagination Pagination
+	// dotted path to the array of items in each page, e.g. "data.items";
+	// paginated results are the items of every page, in one array
+	ItemsField string
+	// dotted path to the next page's cursor or url
+	CursorField string
+	NextField   string
+	CursorParam string
+	// DefaultRESTMaxPages if zero
+	MaxPages int
+
+	// retries for 429s, 5xxs and transport errors, DefaultRESTRetries if zero,
+	// or none if negative; backoff doubles between these bounds, unless
+	// a Retry-After header says otherwise
+	Retries    int
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// client-side limit, or none if zero
+	RequestsPerSecond float64
+
+	// hand back the undecoded body, rather than decoded json
+	Raw bool
+
+	// method for puts, PUT if empty, or else POST; merges are PATCHes.
+	// values other than []byte, strings and readers are sent as json
+	PutMethod string
+	// for content which isn't json, application/octet-stream if empty
+	ContentType string
+
+	// http.DefaultClient if nil
+	Client *http.Client `json:"-"`
+}
+
+// RESTEngine is an APIEngine for json apis, configured rather than coded
+type RESTEngine struct {
+	opts    RESTOptions
+	key     string
+	limiter *rateLimiter
+}
+
+func NewRESTEngine(opts RESTOptions) (*RESTEngine, error) {
+	if opts.URL == "" {
+		return nil, fmt.Errorf("needs url")
+	}
+	if _, err := url.Parse(opts.URL); err != nil {
+		return nil, err
+	}
+	switch opts.Pagination {
+	case NoPagination, LinkPagination:
+	case CursorPagination:
+		if opts.CursorField == "" || opts.CursorParam == "" {
+			return nil, fmt.Errorf("cursor pagination needs cursor field and param")
+		}
+	case NextURLPagination:
+		if opts.NextField == "" {
+			return nil, fmt.Errorf("next url pagination needs next field")
+		}
+	default:
+		return nil, fmt.Errorf("unknown pagination %q", opts.Pagination)
+	}
+	e := RESTEngine{opts: opts, key: opts.Key}
+	if e.key == "" && opts.KeyEnv != "" {
+		e.key = os.Getenv(opts.KeyEnv)
+	}
+	if e.key != "" && opts.KeyHeader == "" && opts.KeyParam == "" {
+		return nil, fmt.Errorf("key needs a header or query parameter")
+	}
+	switch strings.ToUpper(opts.PutMethod) {
+	case "":
+		e.opts.PutMethod = http.MethodPut
+	case http.MethodPut, http.MethodPost:
+		e.opts.PutMethod = strings.ToUpper(opts.PutMethod)
+	default:
+		return nil, fmt.Errorf("put method must be PUT or POST, not %q", opts.PutMethod)
+	}
+	if e.opts.ContentType == "" {
+		e.opts.ContentType = "application/octet-stream"
+	}
+	if e.opts.Client == nil {
+		e.opts.Client = http.DefaultClient
+	}
+	if e.opts.MaxPages == 0 {
+		e.opts.MaxPages = DefaultRESTMaxPages
+	}
+	switch {
+	case e.opts.Retries == 0:
+		e.opts.Retries = DefaultRESTRetries
+	case e.opts.Retries < 0:
+		e.opts.Retries = 0
+	}
+	if e.opts.MinBackoff == 0 {
+		e.opts.MinBackoff = DefaultRESTMinBackoff
+	}
+	if e.opts.MaxBackoff == 0 {
+		e.opts.MaxBackoff = DefaultRESTMaxBackoff
+	}
+	if opts.RequestsPerSecond > 0 {
+		e.limiter = &rateLimiter{interval: time.Duration(float64(time.Second) / opts.RequestsPerSecond)}
+	}
+	return &e, nil
+}
+
+var templateParam = regexp.MustCompile(`{(path|\d+)}`)
+
+// the url of a reference's first page
+func (e *RESTEngine) url(r Reference) (*url.URL, error) {
+	ru := r.URI()
+	p := strings.TrimPrefix(ru.Path, "/")
+	segments := strings.Split(p, "/")
+	var missing error
+	s := templateParam.ReplaceAllStringFunc(e.opts.URL, func(m string) string {
+		name := m[1 : len(m)-1]
+		if name == "path" {
+			escaped := make([]string, len(segments))
+			for i, s := range segments {
+				escaped[i] = url.PathEscape(s)
+			}
+			return strings.Join(escaped, "/")
+		}
+		i, _ := strconv.Atoi(name)
+		if i >= len(segments) || segments[i] == "" {
+			missing = fmt.Errorf("%v has no segment %d", r, i)
+			return ""
+		}
+		return url.PathEscape(segments[i])
+	})
+	if missing != nil {
+		return nil, missing
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	for k, v := range ru.Query() {
+		q[k] = v
+	}
+	if e.key != "" && e.opts.KeyParam != "" {
+		q.Set(e.opts.KeyParam, e.key)
+	}
+	u.RawQuery = q.Encode()
+	return u, nil
+}
+
+func (e *RESTEngine) Get(r Reference) (*http.Response, error) {
+	return e.GetContext(context.Background(), r)
+}
+
+// GetContext fetches every page, returning a response whose body is
+// the last page, or all pages' items if paginated; unsuccessful responses
+// are returned as they are, once retries are exhausted
+func (e *RESTEngine) GetContext(ctx context.Context, r Reference) (*http.Response, error) {
+	u, err := e.url(r)
+	if err != nil {
+		return nil, err
+	}
+	var items []interface{}
+	for page := 0; ; page++ {
+		resp, err := e.do(ctx, http.MethodGet, u, nil, "")
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode/100 != 2 || e.opts.Pagination == NoPagination && e.opts.ItemsField == "" {
+			return resp, nil
+		}
+		buf, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		var doc interface{}
+		if err := json.Unmarshal(buf, &doc); err != nil {
+			return nil, fmt.Errorf("bad json for %v: %w", r, err)
+		}
+		x, ok := field(doc, e.opts.ItemsField).([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("no array at %q for %v", e.opts.ItemsField, r)
+		}
+		items = append(items, x...)
+		next, err := e.next(u, resp, doc)
+		if err != nil {
+			return nil, err
+		}
+		if next == nil || page+1 >= e.opts.MaxPages {
+			buf, err := json.Marshal(items)
+			if err != nil {
+				return nil, err
+			}
+			resp.Body = ioutil.NopCloser(bytes.NewReader(buf))
+			resp.ContentLength = int64(len(buf))
+			resp.Header.Del("Content-Length")
+			return resp, nil
+		}
+		u = next
+	}
+}
+
+// the url of the page after u, or nil if there isn't one
+func (e *RESTEngine) next(u *url.URL, resp *http.Response, doc interface{}) (*url.URL, error) {
+	var s string
+	switch e.opts.Pagination {
+	case LinkPagination:
+		s = linkNext(resp.Header.Values("Link"))
+	case NextURLPagination:
+		s = fieldString(field(doc, e.opts.NextField))
+	case CursorPagination:
+		cursor := fieldString(field(doc, e.opts.CursorField))
+		if cursor == "" {
+			return nil, nil
+		}
+		next := *u
+		q := next.Query()
+		q.Set(e.opts.CursorParam, cursor)
+		next.RawQuery = q.Encode()
+		return &next, nil
+	}
+	if s == "" {
+		return nil, nil
+	}
+	next, err := u.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if e.key != "" && e.opts.KeyParam != "" {
+		// next links don't always carry the key
+		q := next.Query()
+		q.Set(e.opts.KeyParam, e.key)
+		next.RawQuery = q.Encode()
+	}
+	return next, nil
+}
+
+var linkNextPattern = regexp.MustCompile(`<([^>]*)>[^,]*;\s*rel="?next"?`)
+
+func linkNext(links []string) string {
+	for _, l := range links {
+		for _, part := range strings.Split(l, ",") {
+			if m := linkNextPattern.FindStringSubmatch(part); m != nil {
+				return m[1]
+			}
+		}
+	}
+	return ""
+}
+
+// the value at a dotted path in decoded json, or nil
+func field(doc interface{}, path string) interface{} {
+	if path == "" {
+		return doc
+	}
+	for _, k := range strings.Split(path, ".") {
+		m, ok := doc.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		doc = m[k]
+	}
+	return doc
+}
+
+func fieldString(i interface{}) string {
+	switch t := i.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	default:
+		return fmt.Sprint(t)
+	}
+}
+
+// makes a request with rate limiting and retries; body is replayed on
+// each attempt, though posts and patches are only retried after 429s,
+// since they may not be idempotent
+func (e *RESTEngine) do(ctx context.Context, method string, u *url.URL, body []byte, contentType string) (*http.Response, error) {
+	backoff := e.opts.MinBackoff
+	for attempt := 0; ; attempt++ {
+		if e.limiter != nil {
+			if err := e.limiter.wait(ctx); err != nil {
+				return nil, err
+			}
+		}
+		var rd io.Reader
+		if body != nil {
+			rd = bytes.NewReader(body)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, u.String(), rd)
+		if err != nil {
+			return nil, err
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		for k, v := range e.opts.Headers {
+			req.Header.Set(k, v)
+		}
+		if e.key != "" && e.opts.KeyHeader != "" {
+			req.Header.Set(e.opts.KeyHeader, e.opts.KeyPrefix+e.key)
+		}
+		resp, err := e.opts.Client.Do(req)
+		var retry bool
+		switch {
+		case err == nil && resp.StatusCode == http.StatusTooManyRequests:
+			retry = true
+		case method == http.MethodPost || method == http.MethodPatch:
+		default:
+			retry = err != nil || resp.StatusCode/100 == 5
+		}
+		if !retry || attempt >= e.opts.Retries || ctx.Err() != nil {
+			return resp, err
+		}
+		wait := backoff
+		if err == nil {
+			if d, ok := retryAfter(resp.Header.Get("Retry-After")); ok {
+				wait = d
+			}
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		if wait > e.opts.MaxBackoff {
+			wait = e.opts.MaxBackoff
+		}
+		// jitter, so clients don't retry in lockstep
+		wait = wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+// parses a Retry-After header, in seconds or as a date
+func retryAfter(s string) (time.Duration, bool) {
+	if s == "" {
+		return 0, false
+	}
+	if n, err := strconv.Atoi(s); err == nil {
+		return time.Duration(n) * time.Second, true
+	}
+	if t, err := http.ParseTime(s); err == nil {
+		return time.Until(t), true
+	}
+	return 0, false
+}
+
+// Write sends PUT, PATCH or DELETE requests, with puts using PutMethod
+func (e *RESTEngine) Write(ctx context.Context, method string, r Reference, i interface{}) (*http.Response, error) {
+	u, err := e.url(r)
+	if err != nil {
+		return nil, err
+	}
+	if method == http.MethodPut {
+		method = e.opts.PutMethod
+	}
+	var body []byte
+	var ct string
+	switch t := i.(type) {
+	case nil:
+	case []byte:
+		body, ct = t, e.opts.ContentType
+	case string:
+		body, ct = []byte(t), e.opts.ContentType
+	case io.Reader:
+		if body, err = ioutil.ReadAll(t); err != nil {
+			return nil, err
+		}
+		ct = e.opts.ContentType
+	default:
+		if body, err = json.Marshal(t); err != nil {
+			return nil, err
+		}
+		ct = "application/json"
+	}
+	return e.do(ctx, method, u, body, ct)
+}
+
+func (e *RESTEngine) Process(rc io.ReadCloser) (interface{}, error) {
+	defer rc.Close()
+	if e.opts.Raw {
+		return ioutil.ReadAll(rc)
+	}
+	var i interface{}
+	if err := json.NewDecoder(rc).Decode(&i); err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// spaces out requests evenly
+type rateLimiter struct {
+	sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func (l *rateLimiter) wait(ctx context.Context) error {
+	l.Lock()
+	now := time.Now()
+	at := l.next
+	if at.Before(now) {
+		at = now
+	}
+	l.next = at.Add(l.interval)
+	l.Unlock()
+	select {
+	case <-time.After(time.Until(at)):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func init() {
+	RegisterFactory("rest", func(s *Spec, of []StorageCombinator) (StorageCombinator, error) {
+		var o struct {
+			RESTOptions
+			MinBackoff, MaxBackoff specDuration
+		}
+		if err := s.Decode(&o); err != nil {
+			return nil, err
+		}
+		if len(of) > 0 {
+			return nil, fmt.Errorf("doesn't wrap anything")
+		}
+		opts := o.RESTOptions
+		opts.MinBackoff = time.Duration(o.MinBackoff)
+		opts.MaxBackoff = time.Duration(o.MaxBackoff)
+		e, err := NewRESTEngine(opts)
+		if err != nil {
+			return nil, err
+		}
+		return NewAPICombinator(e), nil
+	})
+}
@@ -2,21 +2,26 @@ package sc
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"mime"
 	"net/url"
 	"path"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/s3"
 )
 
 type S3KeyValue struct {
 	defaultBucket, prefix string
 	returnReadCloser      bool
+	uploadOptions         S3UploadOptions
 	svc                   *s3.S3
 }
 
@@ -39,13 +44,22 @@ func (o S3Reference) String() string {
 }
 
 func NewS3KeyValue(defaultBucket, prefix string, returnReadCloser bool, svc *s3.S3) (*S3KeyValue, error) {
+	return NewS3KeyValueWithOptions(defaultBucket, prefix, returnReadCloser, svc, S3UploadOptions{})
+}
+
+func NewS3KeyValueWithOptions(defaultBucket, prefix string, returnReadCloser bool, svc *s3.S3, opts S3UploadOptions) (*S3KeyValue, error) {
 	if defaultBucket == "" {
 		return nil, fmt.Errorf("needs bucket")
 	}
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return nil, err
+	}
 	return &S3KeyValue{
 		defaultBucket:    defaultBucket,
 		prefix:           prefix,
 		returnReadCloser: returnReadCloser,
+		uploadOptions:    opts,
 		svc:              svc,
 	}, nil
 }
@@ -95,94 +109,259 @@ func (fs S3KeyValue) parseS3Reference(r Reference) (*S3Reference, error) {
 }
 
 func (fs S3KeyValue) Get(r Reference) (interface{}, error) {
+	return fs.GetContext(context.Background(), r)
+}
+
+func (fs S3KeyValue) GetContext(ctx context.Context, r Reference) (interface{}, error) {
+	body, err := fs.GetStream(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	if fs.returnReadCloser {
+		return body, nil
+	}
+	return Blob(multiCloser{Reader: contextReader{ctx: ctx, r: body}, closers: []io.Closer{body}})
+}
+
+// streams regardless of how the combinator was configured
+func (fs S3KeyValue) GetStream(ctx context.Context, r Reference) (io.ReadCloser, error) {
 	s3ref, err := fs.s3ref(r)
 	if err != nil {
 		return nil, err
 	}
-	resp, err := fs.svc.GetObject(&s3.GetObjectInput{
+	resp, err := fs.svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(s3ref.Bucket),
 		Key:    aws.String(s3ref.Key),
 	})
 	if err != nil {
 		return nil, wrapNotFound(r, err)
 	}
-	if fs.returnReadCloser {
-		return resp.Body, nil
-	}
-	defer resp.Body.Close()
-	w := new(bytes.Buffer)
-	if _, err := io.Copy(w, resp.Body); err != nil {
-		return nil, err
-	}
-	return w.Bytes(), nil
+	return resp.Body, nil
 }
 
 func (fs S3KeyValue) Put(r Reference, i interface{}) error {
-	s3ref, err := fs.s3ref(r)
+	return fs.PutContext(context.Background(), r, i)
+}
+
+func (fs S3KeyValue) PutContext(ctx context.Context, r Reference, i interface{}) error {
+	return fs.put(ctx, r, i, Conditions{})
+}
+
+func (fs S3KeyValue) put(ctx context.Context, r Reference, i interface{}, cond Conditions) error {
+	opts, err := fs.conditionOptions(cond)
 	if err != nil {
 		return err
 	}
-	var rs io.ReadSeeker
-	cp := func(r io.Reader) error {
-		w := new(bytes.Buffer)
-		if _, err := io.Copy(w, r); err != nil {
-			return err
-		}
-		rs = bytes.NewReader(w.Bytes())
-		return nil
+	s3ref, err := fs.s3ref(r)
+	if err != nil {
+		return err
 	}
+	var body io.Reader
 	switch t := i.(type) {
 	case string:
-		rs = strings.NewReader(t)
+		body = strings.NewReader(t)
 	case []byte:
-		rs = bytes.NewReader(t)
+		body = bytes.NewReader(t)
 	case fmt.Stringer:
-		rs = strings.NewReader(t.String())
-	case io.ReadSeeker:
-		rs = t
+		body = strings.NewReader(t.String())
 	case io.Reader:
-		if err := cp(t); err != nil {
-			return err
-		}
-	case io.ReadCloser:
-		defer t.Close()
-		if err := cp(t); err != nil {
-			return err
+		if c, ok := t.(io.Closer); ok {
+			defer c.Close()
 		}
+		body = t
 	default:
 		return fmt.Errorf("don't know how to handle object type %T", t)
 	}
-	poi := s3.PutObjectInput{
-		Bucket: aws.String(s3ref.Bucket),
-		Key:    aws.String(s3ref.Key),
-		Body:   rs,
-	}
+	var contentType, acl *string
 	if mt := mime.TypeByExtension(path.Ext(s3ref.Key)); mt != "" {
-		poi.ContentType = aws.String(mt)
+		contentType = aws.String(mt)
 	}
 	if s3ref.Public {
-		poi.ACL = aws.String("public-read")
+		acl = aws.String("public-read")
 	}
-	if _, err := fs.svc.PutObject(&poi); err != nil {
-		return err
+	rs, seekable := body.(io.ReadSeeker)
+	if !cond.IsZero() {
+		// conditions only apply to single requests, so buffer
+		if !seekable {
+			w := new(bytes.Buffer)
+			if _, err := io.Copy(w, contextReader{ctx: ctx, r: body}); err != nil {
+				return err
+			}
+			rs = bytes.NewReader(w.Bytes())
+		}
+		return fs.putObject(ctx, r, s3ref, rs, contentType, acl, opts...)
+	}
+	size := int64(-1)
+	if seekable {
+		if _, size, err = remaining(rs); err != nil {
+			return err
+		}
+		if size <= fs.uploads().PartSize {
+			return fs.putObject(ctx, r, s3ref, rs, contentType, acl)
+		}
+	}
+	return fs.upload(ctx, r, s3ref, body, size, contentType, acl)
+}
+
+func (fs S3KeyValue) putObject(ctx context.Context, r Reference, s3ref *S3Reference, body io.ReadSeeker, contentType, acl *string, opts ...request.Option) error {
+	poi := s3.PutObjectInput{
+		Bucket:      aws.String(s3ref.Bucket),
+		Key:         aws.String(s3ref.Key),
+		Body:        body,
+		ContentType: contentType,
+		ACL:         acl,
+	}
+	if _, err := fs.svc.PutObjectWithContext(ctx, &poi, opts...); err != nil {
+		return wrapPreconditionFailed(r, err)
 	}
 	return nil
 }
 
 func (fs S3KeyValue) Merge(r Reference, i interface{}) error {
+	return fs.MergeContext(context.Background(), r, i)
+}
+
+func (fs S3KeyValue) MergeContext(context.Context, Reference, interface{}) error {
 	return unimplemented(fs, "Merge")
 }
 
 func (fs S3KeyValue) Delete(r Reference) error {
+	return fs.DeleteContext(context.Background(), r)
+}
+
+func (fs S3KeyValue) DeleteContext(ctx context.Context, r Reference) error {
+	return fs.delete(ctx, r, Conditions{})
+}
+
+func (fs S3KeyValue) delete(ctx context.Context, r Reference, cond Conditions) error {
+	opts, err := fs.conditionOptions(cond)
+	if err != nil {
+		return err
+	}
 	s3ref, err := fs.s3ref(r)
 	if err != nil {
 		return err
 	}
-	if _, err := fs.svc.DeleteObject(&s3.DeleteObjectInput{
+	if _, err := fs.svc.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
 		Bucket: aws.String(s3ref.Bucket),
 		Key:    aws.String(s3ref.Key),
-	}); err != nil {
-		return err
+	}, opts...); err != nil {
+		return wrapPreconditionFailed(r, err)
 	}
 	return nil
 }
+
+// uses s3's native conditional request headers
+func (fs S3KeyValue) PutIf(ctx context.Context, r Reference, i interface{}, cond Conditions) error {
+	return fs.put(ctx, r, i, cond)
+}
+
+func (fs S3KeyValue) DeleteIf(ctx context.Context, r Reference, cond Conditions) error {
+	return fs.delete(ctx, r, cond)
+}
+
+func (fs S3KeyValue) conditionOptions(cond Conditions) ([]request.Option, error) {
+	if cond.IfVersion != "" {
+		return nil, unsupported(fs, "IfVersion")
+	}
+	var opts []request.Option
+	header := func(k, v string) {
+		if v == "" {
+			return
+		}
+		if v != "*" {
+			v = strconv.Quote(v)
+		}
+		opts = append(opts, func(req *request.Request) {
+			req.HTTPRequest.Header.Set(k, v)
+		})
+	}
+	header("If-Match", cond.IfMatch)
+	header("If-None-Match", cond.IfNoneMatch)
+	return opts, nil
+}
+
+// lists keys beneath the prefix, using s3's own pagination
+func (fs S3KeyValue) List(ctx context.Context, prefix Reference, opts ListOptions) (*ListPage, error) {
+	u := prefix.URI()
+	bucket := fs.defaultBucket
+	var base *url.URL
+	if strings.ToLower(u.Scheme) == "s3" && u.Host != "" {
+		bucket = u.Host
+		base = &url.URL{Scheme: "s3", Host: u.Host}
+	}
+	dir := listPath(u.Path)
+	keyPrefix := path.Join(fs.prefix, removeLeadingSlashes(dir))
+	if keyPrefix != "" {
+		keyPrefix += "/"
+	}
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(keyPrefix),
+	}
+	if !opts.Recursive {
+		input.Delimiter = aws.String("/")
+	}
+	if opts.Limit > 0 {
+		input.MaxKeys = aws.Int64(int64(opts.Limit))
+	}
+	if opts.Token != "" {
+		input.ContinuationToken = aws.String(opts.Token)
+	}
+	out, err := fs.svc.ListObjectsV2WithContext(ctx, input)
+	if err != nil {
+		return nil, wrapNotFound(prefix, err)
+	}
+	toPath := func(key string) string {
+		return listPath(strings.TrimPrefix(key, fs.prefix))
+	}
+	var page ListPage
+	for _, cp := range out.CommonPrefixes {
+		page.Entries = append(page.Entries, Entry{
+			Path:  toPath(strings.TrimSuffix(aws.StringValue(cp.Prefix), "/")),
+			IsDir: true,
+			base:  base,
+		})
+	}
+	for _, o := range out.Contents {
+		key := aws.StringValue(o.Key)
+		if strings.HasSuffix(key, "/") {
+			// folder placeholder
+			continue
+		}
+		page.Entries = append(page.Entries, Entry{
+			Path:    toPath(key),
+			Size:    aws.Int64Value(o.Size),
+			ModTime: aws.TimeValue(o.LastModified),
+			base:    base,
+		})
+	}
+	sort.Slice(page.Entries, func(i, j int) bool {
+		return page.Entries[i].Path < page.Entries[j].Path
+	})
+	if aws.BoolValue(out.IsTruncated) {
+		page.Next = aws.StringValue(out.NextContinuationToken)
+	}
+	return &page, nil
+}
+
+func (fs S3KeyValue) Stat(ctx context.Context, r Reference) (*Metadata, error) {
+	s3ref, err := fs.s3ref(r)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := fs.svc.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s3ref.Bucket),
+		Key:    aws.String(s3ref.Key),
+	})
+	if err != nil {
+		return nil, wrapNotFound(r, err)
+	}
+	return &Metadata{
+		Size:        aws.Int64Value(resp.ContentLength),
+		ModTime:     aws.TimeValue(resp.LastModified),
+		ContentType: aws.StringValue(resp.ContentType),
+		ETag:        strings.Trim(aws.StringValue(resp.ETag), `"`),
+		Version:     aws.StringValue(resp.VersionId),
+	}, nil
+}
@@ -0,0 +1,407 @@
+package sc
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// what s3 reports for objects put without one
+const s3DefaultContentType = "binary/octet-stream"
+
+// zero options upload in parts of s3manager.DefaultUploadPartSize,
+// s3manager.DefaultUploadConcurrency at a time
+type S3UploadOptions struct {
+	// size of each part, at least s3manager.MinUploadPartSize; bodies no
+	// bigger are put in a single request. raised for bodies of known size
+	// which would otherwise need more than s3manager.MaxUploadParts
+	PartSize int64
+	// parts uploaded at once; streams buffer one more part than this
+	Concurrency int
+	// failed uploads are left incomplete rather than aborted, and the next
+	// put to the same key resumes the latest of them, skipping parts already
+	// uploaded with the same content, then sets its content type and acl
+	// to the put's own; see AbortUploads for cleaning up
+	Resumable bool
+}
+
+func (o S3UploadOptions) withDefaults() (S3UploadOptions, error) {
+	switch {
+	case o.PartSize == 0:
+		o.PartSize = s3manager.DefaultUploadPartSize
+	case o.PartSize < s3manager.MinUploadPartSize:
+		return o, fmt.Errorf("part size %d below minimum of %d", o.PartSize, s3manager.MinUploadPartSize)
+	}
+	switch {
+	case o.Concurrency == 0:
+		o.Concurrency = s3manager.DefaultUploadConcurrency
+	case o.Concurrency < 0:
+		return o, fmt.Errorf("bad concurrency %d", o.Concurrency)
+	}
+	return o, nil
+}
+
+// the options, with defaults for combinators not made by a constructor
+func (fs S3KeyValue) uploads() S3UploadOptions {
+	o, _ := fs.uploadOptions.withDefaults()
+	return o
+}
+
+// bytes left to read from a seeker
+func remaining(rs io.ReadSeeker) (offset, size int64, err error) {
+	if offset, err = rs.Seek(0, io.SeekCurrent); err != nil {
+		return
+	}
+	end, err := rs.Seek(0, io.SeekEnd)
+	if err != nil {
+		return
+	}
+	if _, err = rs.Seek(offset, io.SeekStart); err != nil {
+		return
+	}
+	return offset, end - offset, nil
+}
+
+type s3Part struct {
+	number int64
+	body   io.ReadSeeker
+	size   int64
+	// returned to the pool once uploaded, if buffered
+	buf []byte
+}
+
+// uploads a body in parts, reading sections of it concurrently if it's
+// an io.ReaderAt of known size, or else streaming it through a bounded
+// set of buffers; size is -1 if unknown
+func (fs S3KeyValue) upload(ctx context.Context, r Reference, ref *S3Reference, body io.Reader, size int64, contentType, acl *string) error {
+	opts := fs.uploads()
+	partSize := opts.PartSize
+	if size > 0 && (size+partSize-1)/partSize > s3manager.MaxUploadParts {
+		partSize = (size + s3manager.MaxUploadParts - 1) / s3manager.MaxUploadParts
+	}
+	ra, sections := body.(io.ReaderAt)
+	var offset int64
+	if sections && size >= 0 {
+		var err error
+		if offset, _, err = remaining(body.(io.ReadSeeker)); err != nil {
+			return err
+		}
+	} else {
+		sections = false
+		body = contextReader{ctx: ctx, r: body}
+	}
+	free := make(chan []byte, opts.Concurrency+1)
+	for i := 0; i < cap(free); i++ {
+		free <- nil
+	}
+	// the next part, or nil at the end
+	next := func(number int64) (*s3Part, error) {
+		if sections {
+			start := (number - 1) * partSize
+			if start >= size && number > 1 {
+				return nil, nil
+			}
+			n := size - start
+			if n > partSize {
+				n = partSize
+			}
+			return &s3Part{number: number, body: io.NewSectionReader(ra, offset+start, n), size: n}, nil
+		}
+		var buf []byte
+		select {
+		case buf = <-free:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if buf == nil {
+			buf = make([]byte, partSize)
+		}
+		n, err := io.ReadFull(body, buf)
+		switch {
+		case err == io.EOF && number > 1:
+			return nil, nil
+		case err == io.EOF, err == io.ErrUnexpectedEOF:
+		case err != nil:
+			return nil, err
+		}
+		return &s3Part{number: number, body: bytes.NewReader(buf[:n]), size: int64(n), buf: buf}, nil
+	}
+
+	// small streams only need one request
+	first, err := next(1)
+	if err != nil {
+		return err
+	}
+	if first.size < partSize {
+		return fs.putObject(ctx, r, ref, first.body, contentType, acl)
+	}
+
+	var id string
+	var existing map[int64]*s3.Part
+	if opts.Resumable {
+		if id, existing, err = fs.incompleteUpload(ctx, ref); err != nil {
+			return err
+		}
+	}
+	resumed := id != ""
+	if id == "" {
+		out, err := fs.svc.CreateMultipartUploadWithContext(ctx, &s3.CreateMultipartUploadInput{
+			Bucket:      aws.String(ref.Bucket),
+			Key:         aws.String(ref.Key),
+			ContentType: contentType,
+			ACL:         acl,
+		})
+		if err != nil {
+			return err
+		}
+		id = aws.StringValue(out.UploadId)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		mu        sync.Mutex
+		completed []*s3.CompletedPart
+		failed    error
+	)
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if failed == nil {
+			failed = err
+			cancel()
+		}
+	}
+	parts := make(chan *s3Part)
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range parts {
+				etag, err := fs.uploadPart(ctx, ref, id, p, existing[p.number])
+				if p.buf != nil {
+					free <- p.buf
+				}
+				if err != nil {
+					fail(err)
+					continue
+				}
+				mu.Lock()
+				completed = append(completed, &s3.CompletedPart{PartNumber: aws.Int64(p.number), ETag: aws.String(etag)})
+				mu.Unlock()
+			}
+		}()
+	}
+	for p := first; p != nil; {
+		if p.number > s3manager.MaxUploadParts {
+			fail(fmt.Errorf("more than %d parts of %d bytes", s3manager.MaxUploadParts, partSize))
+			break
+		}
+		select {
+		case parts <- p:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		if p, err = next(p.number + 1); err != nil {
+			fail(err)
+		}
+	}
+	close(parts)
+	wg.Wait()
+	if failed == nil {
+		failed = ctx.Err()
+	}
+	if failed == nil {
+		sort.Slice(completed, func(i, j int) bool {
+			return *completed[i].PartNumber < *completed[j].PartNumber
+		})
+		_, failed = fs.svc.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+			Bucket:          aws.String(ref.Bucket),
+			Key:             aws.String(ref.Key),
+			UploadId:        aws.String(id),
+			MultipartUpload: &s3.CompletedMultipartUpload{Parts: completed},
+		})
+	}
+	if failed == nil && resumed {
+		failed = fs.settle(ctx, ref, contentType, acl)
+	}
+	if failed != nil && !opts.Resumable {
+		// not with ctx, which may be why we failed
+		fs.svc.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(ref.Bucket),
+			Key:      aws.String(ref.Key),
+			UploadId: aws.String(id),
+		})
+	}
+	return failed
+}
+
+// uploads a part unless it already was, returning its etag
+func (fs S3KeyValue) uploadPart(ctx context.Context, ref *S3Reference, id string, p *s3Part, existing *s3.Part) (string, error) {
+	if existing != nil && aws.Int64Value(existing.Size) == p.size {
+		h := md5.New()
+		if _, err := io.Copy(h, contextReader{ctx: ctx, r: p.body}); err != nil {
+			return "", err
+		}
+		if etag := aws.StringValue(existing.ETag); strings.Trim(etag, `"`) == hex.EncodeToString(h.Sum(nil)) {
+			return etag, nil
+		}
+		if _, err := p.body.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+	}
+	out, err := fs.svc.UploadPartWithContext(ctx, &s3.UploadPartInput{
+		Bucket:        aws.String(ref.Bucket),
+		Key:           aws.String(ref.Key),
+		UploadId:      aws.String(id),
+		PartNumber:    aws.Int64(p.number),
+		Body:          p.body,
+		ContentLength: aws.Int64(p.size),
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(out.ETag), nil
+}
+
+// gives a resumed upload the content type and acl it was put with; s3
+// doesn't say which an incomplete upload was begun with, so they're
+// only known, and put right, once it's complete
+func (fs S3KeyValue) settle(ctx context.Context, ref *S3Reference, contentType, acl *string) error {
+	head, err := fs.svc.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(ref.Bucket),
+		Key:    aws.String(ref.Key),
+	})
+	if err != nil {
+		return err
+	}
+	want := aws.StringValue(contentType)
+	if want == "" {
+		want = s3DefaultContentType
+	}
+	if aws.StringValue(head.ContentType) != want {
+		// only a copy can change the content type, which s3 only
+		// makes of objects up to 5gb
+		_, err := fs.svc.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+			Bucket:            aws.String(ref.Bucket),
+			Key:               aws.String(ref.Key),
+			CopySource:        aws.String(url.PathEscape(ref.Bucket + "/" + ref.Key)),
+			MetadataDirective: aws.String(s3.MetadataDirectiveReplace),
+			ContentType:       contentType,
+			ACL:               acl,
+		})
+		if err != nil {
+			return fmt.Errorf("can't replace content type %q of resumed upload: %w", aws.StringValue(head.ContentType), err)
+		}
+		return nil
+	}
+	if acl == nil {
+		acl = aws.String(s3.ObjectCannedACLPrivate)
+	}
+	_, err = fs.svc.PutObjectAclWithContext(ctx, &s3.PutObjectAclInput{
+		Bucket: aws.String(ref.Bucket),
+		Key:    aws.String(ref.Key),
+		ACL:    acl,
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "AccessControlListNotSupported" && *acl == s3.ObjectCannedACLPrivate {
+		// buckets without acls can't have had a public upload either
+		return nil
+	}
+	return err
+}
+
+// the latest incomplete upload for a key, if any, and its parts
+func (fs S3KeyValue) incompleteUpload(ctx context.Context, ref *S3Reference) (string, map[int64]*s3.Part, error) {
+	var latest *s3.MultipartUpload
+	if err := fs.svc.ListMultipartUploadsPagesWithContext(ctx, &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(ref.Bucket),
+		Prefix: aws.String(ref.Key),
+	}, func(out *s3.ListMultipartUploadsOutput, _ bool) bool {
+		for _, u := range out.Uploads {
+			if aws.StringValue(u.Key) != ref.Key {
+				continue
+			}
+			if latest == nil || aws.TimeValue(u.Initiated).After(aws.TimeValue(latest.Initiated)) {
+				latest = u
+			}
+		}
+		return true
+	}); err != nil {
+		return "", nil, err
+	}
+	if latest == nil {
+		return "", nil, nil
+	}
+	parts := make(map[int64]*s3.Part)
+	if err := fs.svc.ListPartsPagesWithContext(ctx, &s3.ListPartsInput{
+		Bucket:   aws.String(ref.Bucket),
+		Key:      aws.String(ref.Key),
+		UploadId: latest.UploadId,
+	}, func(out *s3.ListPartsOutput, _ bool) bool {
+		for _, p := range out.Parts {
+			parts[aws.Int64Value(p.PartNumber)] = p
+		}
+		return true
+	}); err != nil {
+		return "", nil, err
+	}
+	return aws.StringValue(latest.UploadId), parts, nil
+}
+
+// AbortUploads aborts incomplete multipart uploads beneath a prefix which
+// began more than olderThan ago, returning how many it aborted; s3 keeps
+// (and charges for) their parts until then, unless a bucket lifecycle
+// rule expires them
+func (fs S3KeyValue) AbortUploads(ctx context.Context, prefix Reference, olderThan time.Duration) (int, error) {
+	u := prefix.URI()
+	bucket := fs.defaultBucket
+	if strings.ToLower(u.Scheme) == "s3" && u.Host != "" {
+		bucket = u.Host
+	}
+	keyPrefix := path.Join(fs.prefix, removeLeadingSlashes(listPath(u.Path)))
+	if keyPrefix != "" {
+		keyPrefix += "/"
+	}
+	cutoff := time.Now().Add(-olderThan)
+	var stale []*s3.MultipartUpload
+	if err := fs.svc.ListMultipartUploadsPagesWithContext(ctx, &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(keyPrefix),
+	}, func(out *s3.ListMultipartUploadsOutput, _ bool) bool {
+		for _, u := range out.Uploads {
+			if aws.TimeValue(u.Initiated).Before(cutoff) {
+				stale = append(stale, u)
+			}
+		}
+		return true
+	}); err != nil {
+		return 0, wrapNotFound(prefix, err)
+	}
+	for i, u := range stale {
+		if _, err := fs.svc.AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(bucket),
+			Key:      u.Key,
+			UploadId: u.UploadId,
+		}); err != nil {
+			return i, err
+		}
+	}
+	return len(stale), nil
+}
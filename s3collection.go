@@ -3,6 +3,7 @@ package sc
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -79,11 +80,15 @@ func (c S3Collection) refMatches(r Reference) bool {
 // think about query "after=isotime" or before="isotime" for only those ones,
 // or fragment "count" for just the count
 func (c S3Collection) Get(r Reference) (interface{}, error) {
+	return c.GetContext(context.Background(), r)
+}
+
+func (c S3Collection) GetContext(ctx context.Context, r Reference) (interface{}, error) {
 	if !c.refMatches(r) {
 		return nil, NotFound
 	}
 	load := func(key string) ([]S3Record, error) {
-		resp, err := c.svc.GetObject(&s3.GetObjectInput{
+		resp, err := c.svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
 			Bucket: aws.String(c.bucket),
 			Key:    aws.String(key),
 		})
@@ -110,7 +115,7 @@ func (c S3Collection) Get(r Reference) (interface{}, error) {
 	{
 		var n int
 		var listError error
-		if err := c.svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		if err := c.svc.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
 			Bucket:  aws.String(c.bucket),
 			MaxKeys: aws.Int64(1000),
 			Prefix:  aws.String(c.prefix),
@@ -148,7 +153,7 @@ func (c S3Collection) Get(r Reference) (interface{}, error) {
 		return sorted[i].Timestamp.Before(sorted[j].Timestamp)
 	})
 	if len(keys) > MaxKeys {
-		if err := c.consolidate(keys, sorted); err != nil {
+		if err := c.consolidate(ctx, keys, sorted); err != nil {
 			return nil, err
 		}
 	}
@@ -175,7 +180,7 @@ func serialize(recs ...S3Record) ([]byte, error) {
 	return w.Bytes(), nil
 }
 
-func (c S3Collection) store(recs ...S3Record) error {
+func (c S3Collection) store(ctx context.Context, recs ...S3Record) error {
 	if len(recs) == 0 {
 		return fmt.Errorf("nothing to store")
 	}
@@ -183,7 +188,7 @@ func (c S3Collection) store(recs ...S3Record) error {
 	if err != nil {
 		return err
 	}
-	if _, err := c.svc.PutObject(&s3.PutObjectInput{
+	if _, err := c.svc.PutObjectWithContext(ctx, &s3.PutObjectInput{
 		Bucket:          aws.String(c.bucket),
 		Key:             aws.String(path.Join(c.prefix, uuid.New().String()) + ".json.gz"),
 		Body:            bytes.NewReader(buf),
@@ -230,6 +235,10 @@ func Halve(list []string) (left, right []string) {
 }
 
 func DeleteKeys(svc *s3.S3, bucket string, keys []string) error {
+	return DeleteKeysContext(context.Background(), svc, bucket, keys)
+}
+
+func DeleteKeysContext(ctx context.Context, svc *s3.S3, bucket string, keys []string) error {
 	if bucket == "" {
 		return fmt.Errorf("no bucket provided")
 	}
@@ -248,31 +257,35 @@ func DeleteKeys(svc *s3.S3, bucket string, keys []string) error {
 				Key: aws.String(k),
 			})
 		}
-		if _, err := svc.DeleteObjects(doi); err != nil {
+		if _, err := svc.DeleteObjectsWithContext(ctx, doi); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (c S3Collection) delete(keys []string) error {
-	return DeleteKeys(c.svc, c.bucket, keys)
+func (c S3Collection) delete(ctx context.Context, keys []string) error {
+	return DeleteKeysContext(ctx, c.svc, c.bucket, keys)
 }
 
-func (c S3Collection) consolidate(keys []string, records []S3Record) error {
+func (c S3Collection) consolidate(ctx context.Context, keys []string, records []S3Record) error {
 	if c.debug {
 		fmt.Fprintf(os.Stderr, "consolidating %d keys\n", len(keys))
 	}
-	if err := c.store(records...); err != nil {
+	if err := c.store(ctx, records...); err != nil {
 		return err
 	}
-	if err := c.delete(keys); err != nil {
+	if err := c.delete(ctx, keys); err != nil {
 		return err
 	}
 	return nil
 }
 
 func (c S3Collection) Merge(r Reference, i interface{}) error {
+	return c.MergeContext(context.Background(), r, i)
+}
+
+func (c S3Collection) MergeContext(ctx context.Context, r Reference, i interface{}) error {
 	if !c.refMatches(r) {
 		return NotFound
 	}
@@ -281,16 +294,24 @@ func (c S3Collection) Merge(r Reference, i interface{}) error {
 		Timestamp: time.Now().UTC(),
 		Payload:   i,
 	}
-	if err := c.store(s3r); err != nil {
+	if err := c.store(ctx, s3r); err != nil {
 		return err
 	}
 	return nil
 }
 
 func (c S3Collection) Put(r Reference, i interface{}) error {
+	return c.PutContext(context.Background(), r, i)
+}
+
+func (c S3Collection) PutContext(context.Context, Reference, interface{}) error {
 	return unimplemented(c, "Put")
 }
 
 func (c S3Collection) Delete(r Reference) error {
+	return c.DeleteContext(context.Background(), r)
+}
+
+func (c S3Collection) DeleteContext(context.Context, Reference) error {
 	return unimplemented(c, "Delete")
 }
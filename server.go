@@ -0,0 +1,234 @@
+package sc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Handler serves a combinator over http, with the request path as reference:
+// GET and HEAD get, PUT puts, DELETE deletes, and PATCH or POST merge.
+// GET with a "list" query parameter lists beneath the path instead,
+// as json, also taking "recursive", "limit" and "token" parameters.
+// If-Match and If-None-Match make puts and deletes conditional, and a
+// "fragment" parameter becomes the reference's fragment, e.g. for versions
+type Handler struct {
+	c StorageCombinator
+}
+
+func NewHandler(c StorageCombinator) *Handler {
+	return &Handler{c: c}
+}
+
+var errBadRequest = errors.New("bad request")
+
+// http status for an error from a combinator
+func StatusCode(err error) int {
+	switch {
+	case err == nil:
+		return http.StatusOK
+	case errors.Is(err, errBadRequest):
+		return http.StatusBadRequest
+	case errors.Is(err, NotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ReadOnlyError):
+		return http.StatusMethodNotAllowed
+	case errors.Is(err, PreconditionFailed):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, NotSupported):
+		return http.StatusNotImplemented
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// query parameters the handler interprets itself, rather than passing on
+var reservedParams = []string{"list", "token", "limit", "recursive", "fragment"}
+
+// the reference for a request's path, with its query passed on
+// for query-driven combinators, and its fragment given by a parameter
+func requestReference(req *http.Request) Reference {
+	q := req.URL.Query()
+	fragment := q.Get("fragment")
+	for _, k := range reservedParams {
+		q.Del(k)
+	}
+	return NewURI(&url.URL{Path: req.URL.Path, RawQuery: q.Encode(), Fragment: fragment})
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	r := requestReference(req)
+	var err error
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		if _, ok := req.URL.Query()["list"]; ok && req.Method == http.MethodGet {
+			err = h.list(w, req)
+		} else {
+			err = h.get(w, req, r)
+		}
+	case http.MethodPut:
+		if cond := requestConditions(req); cond.IsZero() {
+			err = PutContext(ctx, h.c, r, req.Body)
+		} else {
+			err = PutIf(ctx, h.c, r, req.Body, cond)
+		}
+	case http.MethodDelete:
+		if cond := requestConditions(req); cond.IsZero() {
+			err = DeleteContext(ctx, h.c, r)
+		} else {
+			err = DeleteIf(ctx, h.c, r, cond)
+		}
+	case http.MethodPatch, http.MethodPost:
+		err = MergeContext(ctx, h.c, r, req.Body)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, DELETE, PATCH, POST")
+		http.Error(w, "unsupported method", http.StatusMethodNotAllowed)
+		return
+	}
+	switch {
+	case err != nil:
+		code := StatusCode(err)
+		if code == http.StatusMethodNotAllowed {
+			w.Header().Set("Allow", "GET, HEAD")
+		}
+		http.Error(w, err.Error(), code)
+	case req.Method != http.MethodGet && req.Method != http.MethodHead:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func requestConditions(req *http.Request) Conditions {
+	unquote := func(s string) string {
+		return strings.TrimPrefix(strings.Trim(s, `"`), `W/"`)
+	}
+	return Conditions{
+		IfMatch:     unquote(req.Header.Get("If-Match")),
+		IfNoneMatch: unquote(req.Header.Get("If-None-Match")),
+	}
+}
+
+// writes the response itself unless there's an error,
+// which can only happen before anything's written
+func (h *Handler) get(w http.ResponseWriter, req *http.Request, r Reference) error {
+	ctx := req.Context()
+	m, err := Stat(ctx, h.c, r)
+	if errors.Is(err, NotSupported) {
+		m, err = nil, nil
+	} else if err != nil {
+		return err
+	}
+	ct := "application/octet-stream"
+	var modTime time.Time
+	if m != nil {
+		if m.ContentType != "" {
+			ct = m.ContentType
+		}
+		if m.ETag != "" {
+			w.Header().Set("ETag", strconv.Quote(m.ETag))
+		}
+		modTime = m.ModTime
+	} else if x := contentType(req.URL.Path); x != "" {
+		ct = x
+	}
+	// set, so nothing gets sniffed
+	w.Header().Set("Content-Type", ct)
+	if req.Method == http.MethodHead && m != nil {
+		// the metadata is all a head needs, so the content isn't opened
+		req.Header.Del("Range")
+		http.ServeContent(w, req, "", modTime, &forwardSeeker{r: strings.NewReader(""), size: m.Size})
+		return nil
+	}
+	rc, err := GetStream(ctx, h.c, r)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	content, ok := rc.(io.ReadSeeker)
+	switch {
+	case ok:
+	case m != nil:
+		if strings.Contains(req.Header.Get("Range"), ",") {
+			// multiple ranges might need seeking backwards
+			req.Header.Del("Range")
+		}
+		content = &forwardSeeker{r: rc, size: m.Size}
+	default:
+		// size unknown, so no ranges
+		w.Header().Set("Accept-Ranges", "none")
+		if req.Method == http.MethodGet {
+			io.Copy(w, rc)
+		}
+		return nil
+	}
+	http.ServeContent(w, req, "", modTime, content)
+	return nil
+}
+
+func (h *Handler) list(w http.ResponseWriter, req *http.Request) error {
+	q := req.URL.Query()
+	opts := ListOptions{Token: q.Get("token")}
+	if s := q.Get("recursive"); s != "" {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("%w: recursive %v", errBadRequest, err)
+		}
+		opts.Recursive = b
+	}
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("%w: limit %v", errBadRequest, err)
+		}
+		opts.Limit = n
+	}
+	page, err := List(req.Context(), h.c, NewRef(req.URL.Path), opts)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(page)
+}
+
+// lets http.ServeContent serve ranges from a stream of known size,
+// so long as it only needs to seek forwards
+type forwardSeeker struct {
+	r         io.Reader
+	pos, size int64
+}
+
+func (f *forwardSeeker) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	f.pos += int64(n)
+	return n, err
+}
+
+func (f *forwardSeeker) Seek(offset int64, whence int) (int64, error) {
+	var target int64
+	switch whence {
+	case io.SeekStart:
+		target = offset
+	case io.SeekCurrent:
+		target = f.pos + offset
+	case io.SeekEnd:
+		if offset == 0 {
+			// just finding the size
+			return f.size, nil
+		}
+		target = f.size + offset
+	}
+	if target < f.pos {
+		return f.pos, fmt.Errorf("can't seek backwards from %d to %d", f.pos, target)
+	}
+	if _, err := io.CopyN(ioutil.Discard, f, target-f.pos); err != nil {
+		return f.pos, err
+	}
+	return f.pos, nil
+}
@@ -3,39 +3,414 @@ package main
 import (
 	"bufio"
 	"bytes"
-	"database/sql"
-	"encoding/json"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
+	"net/http"
 	"net/url"
 	"os"
 	"os/exec"
-	"path"
+	"os/signal"
+	"path/filepath"
 	"sort"
 	"strings"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/kms"
-	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/blang/semver"
-	"github.com/google/uuid"
 	_ "github.com/snowflakedb/gosnowflake"
 	"github.com/xoba/sc"
 )
 
-var bucket, user, host, passwordVar, ftpFile string
-var retag bool
+const usage = `usage: sc [flags] command [args]
+
+commands:
+  get URI          write content to stdout
+  put URI          store stdin
+  merge URI        merge stdin into existing content
+  delete URI
+  ls [-r] [-l] URI list beneath a prefix
+  stat URI         describe as json
+  cp SRC DST       copy; either may be "-" for stdin or stdout
+  sync [-n] [-delete] [-checksum] [-p N] SRC DST
+                   make everything beneath DST match SRC
+  serve [-addr A] [URI]
+                   serve the store at URI, or else the config stack, over http
+  versions URI     list the versions of something in a versioned store
+  retag            tag this repo with the next patch version
+
+uris are like s3://bucket/key, file:///path, sftp://user@host/path,
+or plain paths, which are files unless -config is given; with
+-versioned, "#version=N" selects a particular version.
+
+exit codes: 1 for general errors, 2 for bad usage, 3 if not found,
+4 if not supported, 5 if a precondition failed.
+
+flags:
+`
+
+var (
+	configFile string
+	versioned  bool
+	stack      sc.StorageCombinator // built from the config file, if any
+)
+
+type usageError string
 
-func init() {
-	flag.StringVar(&bucket, "b", "", "bucket to use for s3, or skip s3 altogether if blank")
-	flag.StringVar(&host, "h", "", "ftp host")
-	flag.StringVar(&user, "u", "", "ftp user")
-	flag.StringVar(&ftpFile, "f", "", "the ftp file to Get")
-	flag.StringVar(&passwordVar, "p", "FTP_PASSWORD", "ftp password environment variable")
-	flag.BoolVar(&retag, "tag", false, "re-tag with next patch version")
+func (e usageError) Error() string {
+	return string(e)
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.StringVar(&configFile, "config", "", "json or yaml stack spec which plain paths address")
+	flag.BoolVar(&versioned, "versioned", false, "treat stores as versioned")
 	flag.Parse()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	go func() {
+		<-sig
+		cancel()
+	}()
+	err := run(ctx, flag.Args())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sc: %v\n", err)
+	}
+	var ue usageError
+	if errors.As(err, &ue) {
+		flag.Usage()
+	}
+	os.Exit(exitCode(err))
+}
+
+func exitCode(err error) int {
+	var ue usageError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &ue):
+		return 2
+	case errors.Is(err, sc.NotFound):
+		return 3
+	case errors.Is(err, sc.NotSupported):
+		return 4
+	case errors.Is(err, sc.PreconditionFailed):
+		return 5
+	default:
+		return 1
+	}
+}
+
+func run(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return usageError("needs a command")
+	}
+	if configFile != "" {
+		s, err := sc.LoadSpec(configFile)
+		if err != nil {
+			return err
+		}
+		if stack, err = sc.Build(s); err != nil {
+			return err
+		}
+	}
+	cmd, args := args[0], args[1:]
+	nargs := func(n int) error {
+		if len(args) != n {
+			return usageError(fmt.Sprintf("%s needs %d arguments", cmd, n))
+		}
+		return nil
+	}
+	switch cmd {
+	case "get":
+		if err := nargs(1); err != nil {
+			return err
+		}
+		return cp(ctx, args[0], "-")
+	case "put":
+		if err := nargs(1); err != nil {
+			return err
+		}
+		return cp(ctx, "-", args[0])
+	case "cp":
+		if err := nargs(2); err != nil {
+			return err
+		}
+		return cp(ctx, args[0], args[1])
+	case "merge":
+		if err := nargs(1); err != nil {
+			return err
+		}
+		c, r, err := resolve(args[0])
+		if err != nil {
+			return err
+		}
+		return sc.MergeContext(ctx, c, r, os.Stdin)
+	case "delete":
+		if err := nargs(1); err != nil {
+			return err
+		}
+		c, r, err := resolve(args[0])
+		if err != nil {
+			return err
+		}
+		return sc.DeleteContext(ctx, c, r)
+	case "stat":
+		if err := nargs(1); err != nil {
+			return err
+		}
+		c, r, err := resolve(args[0])
+		if err != nil {
+			return err
+		}
+		m, err := sc.Stat(ctx, c, r)
+		if err != nil {
+			return err
+		}
+		fmt.Println(m)
+		return nil
+	case "ls":
+		return ls(ctx, args)
+	case "sync":
+		return syncCmd(ctx, args)
+	case "serve":
+		return serve(ctx, args)
+	case "versions":
+		if err := nargs(1); err != nil {
+			return err
+		}
+		versioned = true
+		return versions(ctx, args[0])
+	case "retag":
+		if err := nargs(0); err != nil {
+			return err
+		}
+		return RunRetag()
+	default:
+		return usageError(fmt.Sprintf("unknown command %q", cmd))
+	}
+}
+
+// the store addressed by an argument, and the reference within it
+func resolve(arg string) (sc.StorageCombinator, sc.Reference, error) {
+	c, r, err := resolveRaw(arg)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, stdio := c.(sc.Stdio); versioned && !stdio {
+		c = sc.NewVersioning(c)
+	}
+	return c, r, nil
+}
+
+func resolveRaw(arg string) (sc.StorageCombinator, sc.Reference, error) {
+	if arg == "-" {
+		return sc.Stdio{}, sc.NewRef("-"), nil
+	}
+	u, err := url.Parse(arg)
+	if err != nil {
+		return nil, nil, err
+	}
+	ref := func(p string) sc.Reference {
+		return sc.NewURI(&url.URL{Path: p, RawQuery: u.RawQuery, Fragment: u.Fragment})
+	}
+	switch u.Scheme {
+	case "":
+		if stack != nil {
+			return stack, ref(u.Path), nil
+		}
+		p, err := filepath.Abs(u.Path)
+		if err != nil {
+			return nil, nil, err
+		}
+		u.Path = p
+		fallthrough
+	case "file":
+		root, p := "/", u.Path
+		if versioned {
+			// keep versions next to what they're versions of
+			root, p = filepath.Split(filepath.Clean(p))
+		}
+		c, err := sc.Open("file://" + root)
+		if err != nil {
+			return nil, nil, err
+		}
+		return c, ref(p), nil
+	default:
+		store := *u
+		store.Path, store.RawPath, store.Fragment = "", "", ""
+		c, err := sc.Open(store.String())
+		if err != nil {
+			return nil, nil, err
+		}
+		return c, ref(u.Path), nil
+	}
+}
+
+// streams from one place to another
+func cp(ctx context.Context, src, dst string) error {
+	sc1, sr, err := resolve(src)
+	if err != nil {
+		return err
+	}
+	dc, dr, err := resolve(dst)
+	if err != nil {
+		return err
+	}
+	rc, err := sc.GetStream(ctx, sc1, sr)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return sc.PutContext(ctx, dc, dr, rc)
+}
+
+func ls(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("ls", flag.ContinueOnError)
+	recursive := fs.Bool("r", false, "list recursively")
+	long := fs.Bool("l", false, "show sizes and modification times")
+	if err := fs.Parse(args); err != nil {
+		return usageError(err.Error())
+	}
+	if fs.NArg() != 1 {
+		return usageError("ls needs 1 argument")
+	}
+	c, r, err := resolve(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	opts := sc.ListOptions{Recursive: *recursive}
+	for {
+		page, err := sc.List(ctx, c, r, opts)
+		if err != nil {
+			return err
+		}
+		for _, e := range page.Entries {
+			name := e.Path
+			if e.IsDir {
+				name += "/"
+			}
+			if *long {
+				var mod string
+				if !e.ModTime.IsZero() {
+					mod = e.ModTime.UTC().Format(time.RFC3339)
+				}
+				fmt.Fprintf(w, "%12d %-20s %s\n", e.Size, mod, name)
+			} else {
+				fmt.Fprintln(w, name)
+			}
+		}
+		if page.Next == "" {
+			return w.Flush()
+		}
+		opts.Token = page.Next
+	}
+}
+
+func syncCmd(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("sync", flag.ContinueOnError)
+	var opts sc.SyncOptions
+	fs.BoolVar(&opts.DryRun, "n", false, "only show what would be done")
+	fs.BoolVar(&opts.Delete, "delete", false, "delete what isn't in the source")
+	fs.BoolVar(&opts.Checksum, "checksum", false, "compare content hashes rather than sizes and times")
+	fs.IntVar(&opts.Parallelism, "p", sc.DefaultSyncParallelism, "concurrent transfers")
+	if err := fs.Parse(args); err != nil {
+		return usageError(err.Error())
+	}
+	if fs.NArg() != 2 {
+		return usageError("sync needs 2 arguments")
+	}
+	src, sr, err := resolve(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	dst, dr, err := resolve(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	report, err := sc.Sync(ctx, src, dst, sr, dr, opts)
+	if report != nil {
+		for _, s := range report.Steps {
+			fmt.Println(s)
+		}
+		fmt.Fprintf(os.Stderr, "%d to change, %d unchanged, %d failed\n", len(report.Steps), report.Unchanged, report.Failed)
+	}
+	return err
+}
+
+func serve(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	addr := fs.String("addr", ":8080", "address to listen on")
+	if err := fs.Parse(args); err != nil {
+		return usageError(err.Error())
+	}
+	var c sc.StorageCombinator
+	switch {
+	case fs.NArg() > 1:
+		return usageError("serve needs at most 1 argument")
+	case fs.NArg() == 1:
+		// the store is rooted at the uri, rather than containing it
+		arg := fs.Arg(0)
+		if u, err := url.Parse(arg); err == nil && u.Scheme == "" {
+			p, err := filepath.Abs(arg)
+			if err != nil {
+				return err
+			}
+			arg = "file://" + p
+		}
+		var err error
+		if c, err = sc.Open(arg); err != nil {
+			return err
+		}
+	case stack != nil:
+		c = stack
+	default:
+		return usageError("serve needs a uri or -config")
+	}
+	if versioned {
+		c = sc.NewVersioning(c)
+	}
+	s := &http.Server{Addr: *addr, Handler: sc.NewHandler(c)}
+	go func() {
+		<-ctx.Done()
+		s.Close()
+	}()
+	fmt.Fprintf(os.Stderr, "serving on %s\n", *addr)
+	if err := s.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func versions(ctx context.Context, arg string) error {
+	c, r, err := resolve(arg)
+	if err != nil {
+		return err
+	}
+	u := *r.URI()
+	u.Fragment = "versions"
+	i, err := sc.GetContext(ctx, c, sc.NewURI(&u))
+	if err != nil {
+		return err
+	}
+	list, ok := i.(sc.Versions)
+	if !ok {
+		return fmt.Errorf("bad type: %T", i)
+	}
+	if len(list) == 0 {
+		return fmt.Errorf("no versions of %s (%w)", arg, sc.NotFound)
+	}
+	for _, v := range list {
+		fmt.Printf("%d\t%s\n", v.Version, v.Time.UTC().Format(time.RFC3339))
+	}
+	return nil
 }
 
 func runCmd(name string, args ...string) ([]byte, error) {
@@ -106,387 +481,6 @@ func RunRetag() error {
 	return nil
 }
 
-func newFilesystem(mount string) sc.StorageCombinator {
-	c, err := sc.NewFileSystem(mount)
-	check(err)
-	return c
-}
-
-func newS3(bucket, prefix string) sc.StorageCombinator {
-	p, err := session.NewSessionWithOptions(session.Options{
-		SharedConfigState: session.SharedConfigEnable,
-	})
-	check(err)
-	c, err := sc.NewS3KeyValue(bucket, prefix, true, s3.New(p))
-	check(err)
-	return c
-}
-
-func encrypt(c sc.StorageCombinator) sc.StorageCombinator {
-	p, err := session.NewSessionWithOptions(session.Options{
-		SharedConfigState: session.SharedConfigEnable,
-	})
-	check(err)
-	keyID, err := LoadKeyInfo()
-	check(err)
-	e, err := sc.NewEncrypter(kms.New(p), keyID, c)
-	check(err)
-	return e
-}
-
-// kms.txt contains key id
-func LoadKeyInfo() (keyID string, err error) {
-	buf, err := ioutil.ReadFile("kms.txt")
-	if err != nil {
-		return "", err
-	}
-	return strings.TrimSpace(string(buf)), nil
-}
-
-func newAppender(dir string) sc.StorageCombinator {
-	c, err := sc.NewFileAppender(dir)
-	check(err)
-	return c
-}
-
-func NewStorageCombinator(base string, listRef sc.Reference) (sc.StorageCombinator, error) {
-	log := func(c sc.StorageCombinator) sc.StorageCombinator {
-		return sc.NewPassthrough(fmt.Sprintf("%T", c), c)
-	}
-	store := log(
-		sc.NewListingCombinator(
-			log(
-				sc.NewVersioning(
-					log(
-						newFilesystem(
-							path.Join(base, "fs"),
-						),
-					),
-				),
-			),
-			listRef,
-		),
-	)
-	return store, nil
-}
-
-func appenderTest() {
-	c := sc.NewAppender(newFilesystem("diskstore"))
-	check(c.Put(sc.NewRef("a.txt"), "hi there A\n"))
-	check(c.Put(sc.NewRef("b.txt"), "hi there B\n"))
-	check(c.Put(sc.NewRef("c.txt"), "hi there C\n"))
-	check(c.Merge(sc.NewRef("a.txt"), "hello again!\n"))
-}
-
-const (
-	workingDir = "diskstore/work"
-	cacheDir   = "diskstore/cache"
-	merger     = "diskstore/merger"
-	listPath   = "/list"
-	prefix     = "myprefix"
-)
-
-func OpenDB() (*sql.DB, error) {
-	m, err := dbInfo()
-	if err != nil {
-		return nil, err
-	}
-	u, err := m.URL()
-	if err != nil {
-		return nil, err
-	}
-	return sql.Open("snowflake", u.String())
-}
-
-type DBInfo struct {
-	Account   string
-	User      string
-	Password  string
-	Query     string
-	DB        string
-	Schema    string
-	Warehouse string
-}
-
-func (m DBInfo) URL() (*url.URL, error) {
-	return url.Parse(fmt.Sprintf("%[1]s:%[2]s@%[3]s/%[4]s/%[5]s?warehouse=%[6]s",
-		url.QueryEscape(m.User),
-		url.QueryEscape(m.Password),
-		m.Account,
-		m.DB,
-		m.Schema,
-		m.Warehouse,
-	))
-}
-
-type APIInfo struct {
-	Endpoint string
-	Key      string
-}
-
-func dbInfo() (*DBInfo, error) {
-	f, err := os.Open("snowflake.json")
-	if err != nil {
-		return nil, err
-	}
-	defer f.Close()
-	d := json.NewDecoder(f)
-	var m DBInfo
-	if err := d.Decode(&m); err != nil {
-		return nil, err
-	}
-	return &m, nil
-}
-
-func testhashes() {
-	var c sc.StorageCombinator
-	{
-		fs, err := sc.NewFileSystem("diskstore")
-		check(err)
-		c = fs
-	}
-	c = sc.NewHashedContent(c)
-	blob := []byte(fmt.Sprintf("test 123 at %s\n", time.Now()))
-	fmt.Printf("going to store:\n%q\n", string(blob))
-	r, err := sc.Hash(sc.DefaultHashAlgo, blob)
-	check(err)
-	fmt.Println(r)
-	check(c.Put(r, blob))
-
-	i, err := c.Get(r)
-	check(err)
-	fmt.Printf("got:\n%q\n", show(i))
-}
-
-func main() {
-
-	if retag {
-		check(RunRetag())
-		return
-	}
-
-	if true {
-		p, err := session.NewSessionWithOptions(session.Options{
-			SharedConfigState: session.SharedConfigEnable,
-		})
-		check(err)
-		check(sc.DeleteKeys(s3.New(p), bucket, []string{"5766FA40-0622-457E-A898-F6EA68061388"}))
-		return
-	}
-
-	if true {
-		testhashes()
-		return
-	}
-
-	if true {
-		key, err := ioutil.ReadFile("fred.txt")
-		check(err)
-		api := sc.NewAPICombinator(sc.FredEngine{Key: key})
-		u, err := url.Parse("https://api.stlouisfed.org/fred/series/observations?series_id=GDP&file_type=xml&observation_start=2000-01-01&observation_end=2020-02-01")
-		check(err)
-		r, err := api.Get(sc.NewURI(u))
-		check(err)
-		fmt.Println(show(r))
-		return
-	}
-
-	if host != "" {
-		password := os.Getenv(passwordVar)
-		if password == "" {
-			panic("needs password")
-		}
-		ftp, err := sc.NewFTPCombinator(host, user, password)
-		check(err)
-		r, err := sc.ParseRef("ftp:///" + ftpFile)
-		check(err)
-		i, err := ftp.Get(r)
-		check(err)
-		fmt.Println(show(i))
-		return
-	}
-
-	check(func() error {
-		p, err := session.NewSessionWithOptions(session.Options{
-			SharedConfigState: session.SharedConfigEnable,
-		})
-		if err != nil {
-			return err
-		}
-		listRef := sc.NewRef("/logs")
-		c, err := sc.NewS3Collection(bucket, "testing", listRef, s3.New(p))
-		if err != nil {
-			return err
-		}
-		i, err := c.Get(listRef)
-		if err != nil {
-			return err
-		}
-		list, ok := i.([]interface{})
-		if !ok {
-			return fmt.Errorf("bad type: %T", i)
-		}
-		var n int
-		for _, x := range list {
-			fmt.Println(x)
-			n++
-		}
-		fmt.Printf("%d records\n", n)
-		return nil
-	}())
-	return
-
-	check(func() error {
-
-		p, err := session.NewSessionWithOptions(session.Options{
-			SharedConfigState: session.SharedConfigEnable,
-		})
-		if err != nil {
-			return err
-		}
-
-		storage, err := sc.NewFileSystem("logging/fs")
-		if err != nil {
-			return err
-		}
-		listRef := sc.NewRef("/logs")
-		collection, err := sc.NewS3Collection(bucket, "dir9", listRef, s3.New(p))
-		if err != nil {
-			return err
-		}
-		logger := sc.NewLoggingCombinator(storage, collection, listRef)
-
-		if err := logger.Put(sc.NewRef("a"), "howdy123"); err != nil {
-			return err
-		}
-
-		{
-			i, err := logger.Get(sc.NewRef("a"))
-			if err != nil {
-				return err
-			}
-			fmt.Println(show(i))
-		}
-		return nil
-
-		list := func() error {
-			i, err := logger.Get(listRef)
-			if err != nil {
-				return err
-			}
-			fmt.Println(show(i))
-			return nil
-		}
-
-		if err := list(); err != nil {
-			return err
-		}
-		for i := 0; i < 10; i++ {
-			check(logger.Put(sc.NewRef(fmt.Sprintf("item-%d", i)), uuid.New().String()))
-		}
-		if err := list(); err != nil {
-			return err
-		}
-
-		return nil
-	}())
-	return
-
-	check(func() error {
-		if bucket == "" {
-			return fmt.Errorf("needs bucket")
-		}
-		p, err := session.NewSessionWithOptions(session.Options{
-			SharedConfigState: session.SharedConfigEnable,
-		})
-		if err != nil {
-			return err
-		}
-		ref := sc.NewRef("/collection")
-		c, err := sc.NewS3Collection(bucket, "dir7", ref, s3.New(p))
-		if err != nil {
-			return err
-		}
-		payload := map[string]interface{}{
-			"test":  123,
-			"bogus": []interface{}{"hi!", 4, 5, 6},
-		}
-		if err := c.Merge(ref, payload); err != nil {
-			return err
-		}
-		i, err := c.Get(ref)
-		if err != nil {
-			return err
-		}
-		fmt.Println(show(i))
-		return nil
-	}())
-
-	return
-
-	if false {
-		db, err := OpenDB()
-		check(err)
-		check(db.Ping())
-
-		c := sc.NewDatabaseCombinator(db)
-
-		info, err := dbInfo()
-		check(err)
-		var u url.URL
-		u.Scheme = "sql"
-		u.Path = "/myname"
-		v := make(url.Values)
-		v.Set("query", info.Query)
-		v.Set("format", "csv")
-		v.Set("interface", "string")
-		u.RawQuery = v.Encode()
-
-		r := sc.NewURI(&u)
-		i, err := c.Get(r)
-		check(err)
-		fmt.Println(show(i))
-
-		return
-	}
-
-}
-
-func show(i interface{}) string {
-	s, err := sc.Blob(i)
-	check(err)
-	return string(s)
-}
-
-func Traverse(store sc.StorageCombinator, p string) error {
-	return TraverseIndent(store, p, 0)
-}
-
-// if what we get from a combinator is a directory, like from file system, list and traverse it
-func TraverseIndent(store sc.StorageCombinator, p string, indent int) error {
-	ref := sc.NewRef(p)
-	i, err := store.Get(ref)
-	if err != nil {
-		return err
-	}
-	var prefix string
-	for i := 0; i < indent; i++ {
-		prefix += "  "
-	}
-	switch t := i.(type) {
-	case sc.Directory:
-		for _, x := range t {
-			fmt.Printf("%s%s (%d)\n", prefix, x.Name, x.Size)
-			if x.IsDir {
-				if err := TraverseIndent(store, path.Join(p, x.Name), indent+1); err != nil {
-					return err
-				}
-			}
-		}
-	}
-	return nil
-}
-
 func check(e error) {
 	if e != nil {
 		panic(e)
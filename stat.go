@@ -0,0 +1,79 @@
+package sc
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"path"
+	"time"
+)
+
+// Stater is implemented by combinators that can describe what a
+// reference points to without retrieving it
+type Stater interface {
+	Stat(context.Context, Reference) (*Metadata, error)
+}
+
+type Metadata struct {
+	Size        int64
+	ModTime     time.Time `json:",omitempty"`
+	ContentType string    `json:",omitempty"`
+	ETag        string    `json:",omitempty"` // opaque, changing with the content
+	Version     string    `json:",omitempty"`
+	IsDir       bool
+}
+
+func (m Metadata) String() string {
+	buf, _ := json.Marshal(m)
+	return string(buf)
+}
+
+// Stat describes the referenced item, if c supports it;
+// missing items result in something wrapping NotFound
+func Stat(ctx context.Context, c StorageCombinator, r Reference) (*Metadata, error) {
+	s, ok := c.(Stater)
+	if !ok {
+		return nil, unsupported(c, "Stat")
+	}
+	return s.Stat(ctx, r)
+}
+
+// Exists reports whether the reference can be found, using Stat if
+// available, otherwise falling back to Get
+func Exists(ctx context.Context, c StorageCombinator, r Reference) (bool, error) {
+	var err error
+	if _, ok := c.(Stater); ok {
+		_, err = Stat(ctx, c, r)
+	} else {
+		var i interface{}
+		i, err = GetContext(ctx, c, r)
+		if closer, ok := i.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, NotFound):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+func contentType(p string) string {
+	return mime.TypeByExtension(path.Ext(p))
+}
+
+// hex md5, which matches s3's etag for simple uploads
+func etag(r io.Reader) (string, error) {
+	h := md5.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
@@ -2,8 +2,9 @@ package sc
 
 import (
 	"bytes"
-	"fmt"
+	"context"
 	"io"
+	"io/ioutil"
 	"os"
 )
 
@@ -12,32 +13,52 @@ type Stdio struct {
 }
 
 func (s Stdio) Get(r Reference) (interface{}, error) {
+	return s.GetContext(context.Background(), r)
+}
+
+func (s Stdio) GetContext(ctx context.Context, r Reference) (interface{}, error) {
 	w := new(bytes.Buffer)
-	if _, err := io.Copy(w, os.Stdin); err != nil {
+	if _, err := io.Copy(w, contextReader{ctx: ctx, r: os.Stdin}); err != nil {
 		return nil, err
 	}
 	return w.Bytes(), nil
 }
 
+func (s Stdio) GetStream(ctx context.Context, r Reference) (io.ReadCloser, error) {
+	return ioutil.NopCloser(contextReader{ctx: ctx, r: os.Stdin}), nil
+}
+
 func (s Stdio) Put(r Reference, i interface{}) error {
-	b, err := Blob(i)
-	if err != nil {
+	return s.PutContext(context.Background(), r, i)
+}
+
+func (s Stdio) PutContext(ctx context.Context, r Reference, i interface{}) error {
+	if err := ctx.Err(); err != nil {
 		return err
 	}
-	n, err := os.Stdout.Write(b)
+	rc, err := Reader(i)
 	if err != nil {
 		return err
 	}
-	if n != len(b) {
-		return fmt.Errorf("wrote %d / %d bytes", n, len(b))
+	defer rc.Close()
+	if _, err := io.Copy(os.Stdout, contextReader{ctx: ctx, r: rc}); err != nil {
+		return err
 	}
-	return nil
+	return rc.Close()
+}
+
+func (s Stdio) Delete(r Reference) error {
+	return s.DeleteContext(context.Background(), r)
 }
 
-func (s Stdio) Delete(Reference) error {
+func (s Stdio) DeleteContext(context.Context, Reference) error {
 	return unimplemented(s, "Delete")
 }
 
-func (s Stdio) Merge(Reference, interface{}) error {
+func (s Stdio) Merge(r Reference, i interface{}) error {
+	return s.MergeContext(context.Background(), r, i)
+}
+
+func (s Stdio) MergeContext(context.Context, Reference, interface{}) error {
 	return unimplemented(s, "Merge")
 }
@@ -0,0 +1,65 @@
+package sc
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// StreamGetter is implemented by combinators which can hand back
+// content incrementally, rather than buffering it all first
+type StreamGetter interface {
+	GetStream(context.Context, Reference) (io.ReadCloser, error)
+}
+
+// GetStream returns content as a stream which the caller must close,
+// using c's native streaming if it has any
+func GetStream(ctx context.Context, c StorageCombinator, r Reference) (io.ReadCloser, error) {
+	if s, ok := c.(StreamGetter); ok {
+		return s.GetStream(ctx, r)
+	}
+	i, err := GetContext(ctx, c, r)
+	if err != nil {
+		return nil, err
+	}
+	return Reader(i)
+}
+
+// Reader interprets as a stream something we get from a storage combinator;
+// it is the streaming analogue of Blob
+func Reader(i interface{}) (io.ReadCloser, error) {
+	switch t := i.(type) {
+	case []byte:
+		return ioutil.NopCloser(bytes.NewReader(t)), nil
+	case string:
+		return ioutil.NopCloser(strings.NewReader(t)), nil
+	case io.ReadCloser:
+		return t, nil
+	case io.Reader:
+		return ioutil.NopCloser(t), nil
+	default:
+		b, err := Blob(t)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(bytes.NewReader(b)), nil
+	}
+}
+
+// closes a stream along with whatever it was derived from
+type multiCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (m multiCloser) Close() error {
+	var first error
+	for _, c := range m.closers {
+		if err := c.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
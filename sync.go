@@ -0,0 +1,247 @@
+package sc
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const DefaultSyncParallelism = 4
+
+type SyncOptions struct {
+	// compare md5s of content, rather than modification times, where
+	// both sides have them; files are read to compute theirs
+	Checksum bool
+	// remove destination items which aren't in the source
+	Delete bool
+	// only plan, don't change anything
+	DryRun bool
+	// concurrent transfers, DefaultSyncParallelism if zero
+	Parallelism int
+}
+
+type SyncAction string
+
+const (
+	SyncCopy   SyncAction = "copy"
+	SyncDelete SyncAction = "delete"
+)
+
+// SyncStep is one change a sync makes to the destination
+type SyncStep struct {
+	Action SyncAction
+	Path   string // relative to the prefixes
+	Reason string
+	Size   int64
+	Err    error `json:",omitempty"`
+}
+
+func (s SyncStep) String() string {
+	out := fmt.Sprintf("%s %s (%s)", s.Action, s.Path, s.Reason)
+	if s.Err != nil {
+		out += fmt.Sprintf(": %v", s.Err)
+	}
+	return out
+}
+
+type SyncReport struct {
+	Steps     []SyncStep // in path order
+	Unchanged int
+	Failed    int
+}
+
+// Sync makes everything beneath dstPrefix match srcPrefix, copying only
+// what differs; both combinators must support List. the report lists the
+// steps taken, or which would be taken on a dry run
+func Sync(ctx context.Context, src, dst StorageCombinator, srcPrefix, dstPrefix Reference, opts SyncOptions) (*SyncReport, error) {
+	list := func(c StorageCombinator, prefix Reference) (map[string]Entry, error) {
+		entries, err := ListAll(ctx, c, prefix, true)
+		if errors.Is(err, NotFound) {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		dir := listPath(prefix.URI().Path)
+		m := make(map[string]Entry)
+		for _, e := range entries {
+			m[relativePath(dir, e.Path)] = e
+		}
+		return m, nil
+	}
+	have, err := list(src, srcPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("can't list source: %w", err)
+	}
+	want, err := list(dst, dstPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("can't list destination: %w", err)
+	}
+	du := dstPrefix.URI()
+	dstRef := func(rel string) Reference {
+		return NewURI(&url.URL{Scheme: du.Scheme, Host: du.Host, Path: path.Join(listPath(du.Path), rel)})
+	}
+	var report SyncReport
+	for rel, s := range have {
+		d, ok := want[rel]
+		reason, err := differs(ctx, src, dst, s, dstRef(rel), d, ok, opts.Checksum)
+		if err != nil {
+			return nil, err
+		}
+		if reason == "" {
+			report.Unchanged++
+			continue
+		}
+		report.Steps = append(report.Steps, SyncStep{Action: SyncCopy, Path: rel, Reason: reason, Size: s.Size})
+	}
+	if opts.Delete {
+		for rel, d := range want {
+			if _, ok := have[rel]; !ok {
+				report.Steps = append(report.Steps, SyncStep{Action: SyncDelete, Path: rel, Reason: "not in source", Size: d.Size})
+			}
+		}
+	}
+	sort.Slice(report.Steps, func(i, j int) bool {
+		return report.Steps[i].Path < report.Steps[j].Path
+	})
+	if opts.DryRun {
+		return &report, nil
+	}
+	n := opts.Parallelism
+	if n <= 0 {
+		n = DefaultSyncParallelism
+	}
+	sem := make(chan struct{}, n)
+	var wg sync.WaitGroup
+	for i := range report.Steps {
+		step := &report.Steps[i]
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			step.Err = ctx.Err()
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			switch step.Action {
+			case SyncCopy:
+				step.Err = syncCopy(ctx, src, dst, have[step.Path], dstRef(step.Path))
+			case SyncDelete:
+				if err := DeleteContext(ctx, dst, dstRef(step.Path)); err != nil && !errors.Is(err, NotFound) {
+					step.Err = err
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	var first error
+	for _, s := range report.Steps {
+		if s.Err != nil {
+			report.Failed++
+			if first == nil {
+				first = s.Err
+			}
+		}
+	}
+	if first != nil {
+		return &report, fmt.Errorf("%d of %d steps failed, first: %w", report.Failed, len(report.Steps), first)
+	}
+	return &report, nil
+}
+
+// path of p relative to dir, both in listPath form
+func relativePath(dir, p string) string {
+	if dir == "/" {
+		return strings.TrimPrefix(p, "/")
+	}
+	return strings.TrimPrefix(p, dir+"/")
+}
+
+// why the destination needs a copy of the source item, or "" if it doesn't;
+// items are the same if sizes agree and, with checksums, their md5s match,
+// or else the source isn't newer, where both modification times are known
+func differs(ctx context.Context, src, dst StorageCombinator, s Entry, dr Reference, d Entry, exists, checksum bool) (string, error) {
+	switch {
+	case !exists:
+		return "missing", nil
+	case s.Size != d.Size:
+		return "size differs", nil
+	}
+	if checksum {
+		same, ok, err := sameContent(ctx, src, dst, s, dr)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			if !same {
+				return "content differs", nil
+			}
+			return "", nil
+		}
+	}
+	if !s.ModTime.IsZero() && !d.ModTime.IsZero() && s.ModTime.After(d.ModTime) {
+		return "source newer", nil
+	}
+	return "", nil
+}
+
+// implemented by combinators whose etags aren't md5s, but
+// which can compute them, like FileSystem
+type md5Summer interface {
+	contentMD5(context.Context, Reference) (string, error)
+}
+
+// compares md5s of source and destination, if both are known;
+// multipart uploads to s3, for instance, have etags which aren't
+func sameContent(ctx context.Context, src, dst StorageCombinator, sr, dr Reference) (same, ok bool, err error) {
+	sum := func(c StorageCombinator, r Reference) (string, error) {
+		if s, ok := c.(md5Summer); ok {
+			return s.contentMD5(ctx, r)
+		}
+		m, err := Stat(ctx, c, r)
+		switch {
+		case errors.Is(err, NotSupported):
+			return "", nil
+		case err != nil:
+			return "", err
+		}
+		if isMD5(m.ETag) {
+			return strings.ToLower(m.ETag), nil
+		}
+		return "", nil
+	}
+	s, err := sum(src, sr)
+	if err != nil || s == "" {
+		return false, false, err
+	}
+	d, err := sum(dst, dr)
+	if err != nil || d == "" {
+		return false, false, err
+	}
+	return s == d, true, nil
+}
+
+func isMD5(etag string) bool {
+	if len(etag) != 2*md5.Size {
+		return false
+	}
+	_, err := hex.DecodeString(etag)
+	return err == nil
+}
+
+func syncCopy(ctx context.Context, src, dst StorageCombinator, s Entry, dr Reference) error {
+	rc, err := GetStream(ctx, src, s)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return PutContext(ctx, dst, dr, rc)
+}
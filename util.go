@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"net/url"
 	"os"
 	"reflect"
@@ -110,6 +111,11 @@ func wrapNotFound(r Reference, err error) error {
 			err = fmt.Errorf("%w (no such bucket; %v)", NotFound, r)
 		case s3.ErrCodeNoSuchKey:
 			err = fmt.Errorf("%w (no such key; %v)", NotFound, r)
+		default:
+			// head requests have no body, so no code but the status
+			if rf, ok := aerr.(awserr.RequestFailure); ok && rf.StatusCode() == http.StatusNotFound {
+				err = fmt.Errorf("%w (%v)", NotFound, r)
+			}
 		}
 	}
 	return err
@@ -149,8 +155,6 @@ func Blob(i interface{}) ([]byte, error) {
 		return t, nil
 	case string:
 		return []byte(t), nil
-	case io.Reader:
-		return cp(t)
 	case io.ReadCloser:
 		x, err := cp(t)
 		if err != nil {
@@ -160,11 +164,25 @@ func Blob(i interface{}) ([]byte, error) {
 			return nil, err
 		}
 		return x, nil
-	case Observations, []interface{}, []FileReference, Versions, []S3Record:
+	case io.Reader:
+		return cp(t)
+	case Observations, SeriesList, Categories, VintageDates, []interface{}, map[string]interface{}, []FileReference, Versions, []S3Record:
 		return encode(t)
 	default:
 		return nil, fmt.Errorf("can't handle type %T", t)
 	}
 }
 
+// size in bytes of something stored in a combinator, if easily known
+func sizeOf(i interface{}) int64 {
+	switch t := i.(type) {
+	case []byte:
+		return int64(len(t))
+	case string:
+		return int64(len(t))
+	default:
+		return 0
+	}
+}
+
 const HashPrefix = `EAE18B82-F047-4913-BFE7-CF5B9E3B35AB`
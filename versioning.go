@@ -2,6 +2,7 @@ package sc
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
 	"encoding/json"
 	"errors"
@@ -12,6 +13,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/google/uuid"
 )
 
 // usurps the uri fragment for versioning operations
@@ -57,18 +60,20 @@ func (v Versions) Max() (out int) {
 	return v[n-1].Version
 }
 
-func hashRef(r Reference, v int) Reference {
+// nonce makes targets unique even for racing writers of the same version
+func hashRef(r Reference, v int, nonce string) Reference {
 	h := md5.New()
 	e := json.NewEncoder(h)
 	e.SetEscapeHTML(false)
 	e.Encode(r.URI().String())
 	e.Encode(v)
+	e.Encode(nonce)
 	e.Encode(`D6871E1B-4C52-423B-B526-1F2D82D1C996`)
 	return NewRef(fmt.Sprintf("%x", h.Sum(nil)))
 }
 
-func (v Versioning) load(r Reference) (Versions, error) {
-	i, err := v.c.Get(r)
+func (v Versioning) load(ctx context.Context, r Reference) (Versions, error) {
+	i, err := GetContext(ctx, v.c, r)
 	if err != nil {
 		return nil, err
 	}
@@ -101,6 +106,10 @@ func (v Versioning) load(r Reference) (Versions, error) {
 // unless there is also a "version" query parameter, then
 // that version is retrieved
 func (v Versioning) Get(r Reference) (interface{}, error) {
+	return v.GetContext(context.Background(), r)
+}
+
+func (v Versioning) GetContext(ctx context.Context, r Reference) (interface{}, error) {
 	if err := v.checkReference(r); err != nil {
 		return nil, err
 	}
@@ -108,7 +117,7 @@ func (v Versioning) Get(r Reference) (interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
-	versions, err := v.load(r2)
+	versions, err := v.load(ctx, r2)
 	if err != nil {
 		return nil, err
 	}
@@ -130,7 +139,7 @@ func (v Versioning) Get(r Reference) (interface{}, error) {
 			if err != nil {
 				return nil, err
 			}
-			return v.c.Get(r)
+			return GetContext(ctx, v.c, r)
 		default:
 			return nil, fmt.Errorf("unrecognized uri fragment: %q", u.Fragment)
 		}
@@ -143,7 +152,7 @@ func (v Versioning) Get(r Reference) (interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
-	return v.c.Get(r3)
+	return GetContext(ctx, v.c, r3)
 }
 
 func (v Versioning) checkReference(r Reference) error {
@@ -159,29 +168,89 @@ func (v Versioning) checkReference(r Reference) error {
 }
 
 func (v Versioning) Put(r Reference, i interface{}) error {
+	return v.PutContext(context.Background(), r, i)
+}
+
+func (v Versioning) PutContext(ctx context.Context, r Reference, i interface{}) error {
+	return v.PutIf(ctx, r, i, Conditions{})
+}
+
+// content goes to a unique target, then the version index is swapped in
+// with a conditional put where the underlying combinator supports it,
+// retrying if a concurrent writer got there first.
+// conditions refer to the current version, as described by Stat
+func (v Versioning) PutIf(ctx context.Context, r Reference, i interface{}, cond Conditions) error {
 	if err := v.checkReference(r); err != nil {
 		return err
 	}
-	versions, err := v.load(r)
+	versions, err := v.load(ctx, r)
 	if err != nil && !errors.Is(err, NotFound) {
 		return err
 	}
-	newVersion := versions.Max() + 1
-	targetURI := hashRef(r, newVersion)
-	if err := v.c.Put(targetURI, i); err != nil {
+	targetURI := hashRef(r, versions.Max()+1, uuid.New().String())
+	if err := PutContext(ctx, v.c, targetURI, i); err != nil {
 		return err
 	}
-	versions = append(versions, VersionRecord{
-		SourceURI: r.URI().String(),
-		TargetURI: targetURI.URI().String(),
-		Version:   newVersion,
-		Time:      time.Now().UTC(),
-	})
-	w := new(bytes.Buffer)
-	if err := versions.Encode(w); err != nil {
+	var conditionError error
+	if err := retryConflicts(ctx, func() error {
+		index, conditional, err := beginSwap(ctx, v.c, r)
+		if err != nil {
+			return err
+		}
+		versions, err := v.load(ctx, r)
+		if err != nil && !errors.Is(err, NotFound) {
+			return err
+		}
+		if err := v.checkConditions(ctx, r, versions, cond); err != nil {
+			conditionError = err
+			return nil
+		}
+		versions = append(versions, VersionRecord{
+			SourceURI: r.URI().String(),
+			TargetURI: targetURI.URI().String(),
+			Version:   versions.Max() + 1,
+			Time:      time.Now().UTC(),
+		})
+		w := new(bytes.Buffer)
+		if err := versions.Encode(w); err != nil {
+			return err
+		}
+		return endSwap(ctx, v.c, r, w.Bytes(), index, conditional)
+	}); err != nil || conditionError != nil {
+		// clean up orphaned content
+		DeleteContext(ctx, v.c, targetURI)
+		if err == nil {
+			err = conditionError
+		}
 		return err
 	}
-	return v.c.Put(r, w.Bytes())
+	return nil
+}
+
+func (v Versioning) checkConditions(ctx context.Context, r Reference, versions Versions, cond Conditions) error {
+	if cond.IsZero() {
+		return nil
+	}
+	if len(versions) == 0 {
+		return cond.check(r, nil)
+	}
+	latest := versions[len(versions)-1]
+	m := Metadata{Version: strconv.Itoa(latest.Version)}
+	isTag := func(s string) bool {
+		return s != "" && s != "*"
+	}
+	if isTag(cond.IfMatch) || isTag(cond.IfNoneMatch) {
+		target, err := ParseRef(latest.TargetURI)
+		if err != nil {
+			return err
+		}
+		tm, err := Stat(ctx, v.c, target)
+		if err != nil {
+			return err
+		}
+		m.ETag = tm.ETag
+	}
+	return cond.check(r, &m)
 }
 
 func (versions Versions) Encode(w io.Writer) error {
@@ -198,16 +267,100 @@ func (versions Versions) Encode(w io.Writer) error {
 // need to think about this: should actually add a "delete" version,
 // not delete it per se!!!
 func (v Versioning) Delete(r Reference) error {
+	return v.DeleteContext(context.Background(), r)
+}
+
+func (v Versioning) DeleteContext(ctx context.Context, r Reference) error {
 	if err := v.checkReference(r); err != nil {
 		return err
 	}
 	return unimplemented(v, "Delete")
+}
 
+// deletes aren't supported, so neither are conditional ones
+func (v Versioning) DeleteIf(ctx context.Context, r Reference, cond Conditions) error {
+	if err := v.checkReference(r); err != nil {
+		return err
+	}
+	return unsupported(v, "DeleteIf")
 }
 
 func (v Versioning) Merge(r Reference, i interface{}) error {
+	return v.MergeContext(context.Background(), r, i)
+}
+
+func (v Versioning) MergeContext(ctx context.Context, r Reference, i interface{}) error {
 	if err := v.checkReference(r); err != nil {
 		return err
 	}
 	return unimplemented(v, "Merge")
 }
+
+// Current returns the record of the version a reference resolves to,
+// which is the latest unless a "version=N" fragment is given
+func (v Versioning) Current(ctx context.Context, r Reference) (*VersionRecord, error) {
+	if err := v.checkReference(r); err != nil {
+		return nil, err
+	}
+	r2, err := RemoveFragment(r)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := v.load(ctx, r2)
+	if err != nil {
+		return nil, err
+	}
+	if m := v.p.FindStringSubmatch(r.URI().Fragment); m != nil && m[2] == "version" {
+		x, err := strconv.ParseInt(m[3], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return versions.Find(int(x))
+	}
+	if len(versions) == 0 {
+		return nil, NotFound
+	}
+	return &versions[len(versions)-1], nil
+}
+
+// describes the current version, using the underlying combinator's
+// metadata of its content, so not where that's unsupported
+func (v Versioning) Stat(ctx context.Context, r Reference) (*Metadata, error) {
+	vr, err := v.Current(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	target, err := ParseRef(vr.TargetURI)
+	if err != nil {
+		return nil, err
+	}
+	m, err := Stat(ctx, v.c, target)
+	if err != nil {
+		// without the content's metadata, its size is unknown
+		return nil, err
+	}
+	m.ModTime = vr.Time
+	m.Version = strconv.Itoa(vr.Version)
+	m.ContentType = contentType(r.URI().Path)
+	return m, nil
+}
+
+// streams the content of the version the reference resolves to
+func (v Versioning) GetStream(ctx context.Context, r Reference) (io.ReadCloser, error) {
+	if m := v.p.FindStringSubmatch(r.URI().Fragment); m != nil && m[1] == "versions" {
+		i, err := v.GetContext(ctx, r)
+		if err != nil {
+			return nil, err
+		}
+		return Reader(i)
+	}
+	vr, err := v.Current(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	target, err := ParseRef(vr.TargetURI)
+	if err != nil {
+		return nil, err
+	}
+	return GetStream(ctx, v.c, target)
+}
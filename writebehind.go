@@ -0,0 +1,206 @@
+package sc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultFlushInterval = time.Second
+	DefaultMaxPending    = 1024
+)
+
+// a write accepted by the cache but not yet flushed to the underlying combinator
+type pendingWrite struct {
+	r       Reference
+	i       interface{}
+	deleted bool
+	seq     uint64
+}
+
+// queue of writes for a write-behind cache, flushed in batches
+type writeBehind struct {
+	u          StorageCombinator
+	interval   time.Duration
+	maxPending int
+	onError    func(Reference, error)
+
+	mu      sync.Mutex
+	pending map[string]*pendingWrite // latest write per key
+	seq     uint64
+	space   chan struct{} // closed whenever pending shrinks
+	err     error         // first background failure since last Flush
+	closed  bool
+
+	flushing sync.Mutex // one batch at a time
+	kick     chan struct{}
+	stop     chan struct{}
+	stopped  chan struct{}
+}
+
+func newWriteBehind(u StorageCombinator, opts CacheOptions) *writeBehind {
+	w := &writeBehind{
+		u:          u,
+		interval:   opts.FlushInterval,
+		maxPending: opts.MaxPending,
+		onError:    opts.OnFlushError,
+		pending:    make(map[string]*pendingWrite),
+		space:      make(chan struct{}),
+		kick:       make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+	if w.interval <= 0 {
+		w.interval = DefaultFlushInterval
+	}
+	if w.maxPending <= 0 {
+		w.maxPending = DefaultMaxPending
+	}
+	go w.run()
+	return w
+}
+
+func (w *writeBehind) run() {
+	defer close(w.stopped)
+	t := time.NewTicker(w.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-t.C:
+		case <-w.kick:
+		}
+		if err := w.flush(context.Background()); err != nil {
+			w.mu.Lock()
+			if w.err == nil {
+				w.err = err
+			}
+			w.mu.Unlock()
+		}
+	}
+}
+
+// asks for a flush without waiting for it
+func (w *writeBehind) wake() {
+	select {
+	case w.kick <- struct{}{}:
+	default:
+	}
+}
+
+// queues a write, blocking while the queue is full
+func (w *writeBehind) enqueue(ctx context.Context, r Reference, i interface{}, deleted bool) error {
+	k := key(r)
+	for {
+		w.mu.Lock()
+		if w.closed {
+			w.mu.Unlock()
+			return fmt.Errorf("write-behind cache is closed")
+		}
+		if _, ok := w.pending[k]; ok || len(w.pending) < w.maxPending {
+			w.seq++
+			w.pending[k] = &pendingWrite{r: r, i: i, deleted: deleted, seq: w.seq}
+			full := len(w.pending) >= w.maxPending
+			w.mu.Unlock()
+			if full {
+				w.wake()
+			}
+			return nil
+		}
+		space := w.space
+		w.mu.Unlock()
+		w.wake()
+		select {
+		case <-space:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// the unflushed write for a reference, if any
+func (w *writeBehind) lookup(r Reference) (*pendingWrite, bool) {
+	if w == nil {
+		return nil, false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	p, ok := w.pending[key(r)]
+	return p, ok
+}
+
+// writes everything pending, in order, keeping failures queued for the next attempt
+func (w *writeBehind) flush(ctx context.Context) error {
+	w.flushing.Lock()
+	defer w.flushing.Unlock()
+	w.mu.Lock()
+	batch := make([]pendingWrite, 0, len(w.pending))
+	for _, p := range w.pending {
+		batch = append(batch, *p)
+	}
+	w.mu.Unlock()
+	sort.Slice(batch, func(i, j int) bool {
+		return batch[i].seq < batch[j].seq
+	})
+	var first error
+	for _, p := range batch {
+		var err error
+		if p.deleted {
+			if err = DeleteContext(ctx, w.u, p.r); errors.Is(err, NotFound) {
+				err = nil
+			}
+		} else {
+			err = PutContext(ctx, w.u, p.r, p.i)
+		}
+		if err != nil {
+			err = fmt.Errorf("can't flush %v: %w", p.r, err)
+			if first == nil {
+				first = err
+			}
+			if w.onError != nil {
+				w.onError(p.r, err)
+			}
+			continue
+		}
+		w.mu.Lock()
+		// unless overwritten meanwhile
+		if q, ok := w.pending[key(p.r)]; ok && q.seq == p.seq {
+			delete(w.pending, key(p.r))
+			close(w.space)
+			w.space = make(chan struct{})
+		}
+		w.mu.Unlock()
+	}
+	return first
+}
+
+// flushes, reporting any failure since the last call
+func (w *writeBehind) Flush(ctx context.Context) error {
+	err := w.flush(ctx)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err == nil {
+		err = w.err
+	}
+	w.err = nil
+	return err
+}
+
+// stops background flushing after a final flush
+func (w *writeBehind) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	w.mu.Unlock()
+	close(w.stop)
+	<-w.stopped
+	return w.Flush(context.Background())
+}